@@ -0,0 +1,104 @@
+package answers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+//Load reads the given answers files in order, later files overriding earlier ones, and then
+//applies the KEY=VALUE overrides.
+func Load(files []string, overrides []string) (map[string]interface{}, error) {
+	variables := map[string]interface{}{}
+	for _, file := range files {
+		vars, err := LoadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range vars {
+			variables[k] = v
+		}
+	}
+	for _, s := range overrides {
+		parts := strings.SplitN(s, "=", 2)
+		if len(parts) != 2 || !regKey.MatchString(parts[0]) {
+			return nil, fmt.Errorf("Parse '%s' fail, needs the form 'KEY=VALUE'", s)
+		}
+		variables[parts[0]] = parts[1]
+	}
+	return variables, nil
+}
+
+//LoadFile reads a single answers file. The format is chosen by extension: .yml/.yaml for YAML,
+//.json for JSON and dotenv syntax for anything else.
+func LoadFile(file string) (map[string]interface{}, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "read answers file failed")
+	}
+	var vars map[string]interface{}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yml", ".yaml":
+		vars, err = ParseYAML(content)
+	case ".json":
+		vars, err = ParseJSON(content)
+	default:
+		vars, err = ParseDotenv(content)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse answers file '%s' failed", file)
+	}
+	return vars, nil
+}
+
+//ParseYAML parses a flat YAML mapping of answers.
+func ParseYAML(content []byte) (map[string]interface{}, error) {
+	raw := map[string]interface{}{}
+	if err := yaml.Unmarshal(content, &raw); err != nil {
+		return nil, err
+	}
+	return flatten(raw)
+}
+
+//ParseJSON parses a flat JSON object of answers.
+func ParseJSON(content []byte) (map[string]interface{}, error) {
+	raw := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		if syntaxErr, ok := err.(*json.SyntaxError); ok {
+			return nil, fmt.Errorf("line %d: %v", lineOf(content, syntaxErr.Offset), err)
+		}
+		return nil, err
+	}
+	return flatten(raw)
+}
+
+//flatten turns scalar answers into strings, which is what Rancher stores as stack environment.
+func flatten(raw map[string]interface{}) (map[string]interface{}, error) {
+	variables := map[string]interface{}{}
+	for k, v := range raw {
+		switch v.(type) {
+		case nil:
+			variables[k] = ""
+		case map[string]interface{}, map[interface{}]interface{}, []interface{}:
+			return nil, fmt.Errorf("answer '%s' must be a scalar value", k)
+		default:
+			variables[k] = fmt.Sprint(v)
+		}
+	}
+	return variables, nil
+}
+
+func lineOf(content []byte, offset int64) int {
+	if offset > int64(len(content)) {
+		offset = int64(len(content))
+	}
+	return bytes.Count(content[:offset], []byte("\n")) + 1
+}
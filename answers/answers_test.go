@@ -0,0 +1,99 @@
+package answers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseDotenv(t *testing.T) {
+	content := `# comment
+
+export FOO=bar # trailing comment
+EMPTY=
+SINGLE='a $b \n'
+DOUBLE="tab\there \"quoted\""
+MULTI="line1
+line2"
+`
+	vars, err := ParseDotenv([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"FOO":    "bar",
+		"EMPTY":  "",
+		"SINGLE": `a $b \n`,
+		"DOUBLE": "tab\there \"quoted\"",
+		"MULTI":  "line1\nline2",
+	}
+	if len(vars) != len(expected) {
+		t.Errorf("expected %d variables, got %v", len(expected), vars)
+	}
+	for k, v := range expected {
+		if vars[k] != v {
+			t.Errorf("variable %s: expected %q, got %q", k, v, vars[k])
+		}
+	}
+}
+
+func TestParseErrorsReportLine(t *testing.T) {
+	if _, err := ParseDotenv([]byte("A=1\n\nB=\"open\n")); err == nil || !strings.HasPrefix(err.Error(), "line 3:") {
+		t.Errorf("expected error on line 3, got %v", err)
+	}
+	if _, err := ParseDotenv([]byte("A=1\nbad key=2\n")); err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Errorf("expected error on line 2, got %v", err)
+	}
+	if _, err := ParseJSON([]byte("{\n\"A\": 1,\n}")); err == nil || !strings.HasPrefix(err.Error(), "line 3:") {
+		t.Errorf("expected error on line 3, got %v", err)
+	}
+}
+
+func TestParseYAML(t *testing.T) {
+	vars, err := ParseYAML([]byte("REPLICAS: 3\nDEBUG: true\nNAME: web\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vars["REPLICAS"] != "3" || vars["DEBUG"] != "true" || vars["NAME"] != "web" {
+		t.Errorf("unexpected variables %v", vars)
+	}
+	if _, err := ParseYAML([]byte("NESTED:\n  a: b\n")); err == nil {
+		t.Error("expected error for nested value")
+	}
+}
+
+func TestSecrets(t *testing.T) {
+	os.Setenv("UPGRADER_TEST_SECRET", "s3cret")
+	defer os.Unsetenv("UPGRADER_TEST_SECRET")
+
+	dir, err := ioutil.TempDir("", "vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "db.yml"), []byte("password: hunter2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	secrets := NewSecrets()
+	secrets.Register("vault", &FileVault{Root: dir})
+	vars, err := secrets.ResolveAll(map[string]interface{}{
+		"A": "${env:UPGRADER_TEST_SECRET}",
+		"B": "pre-${vault:db#password}",
+		"C": "${OTHER}",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vars["A"] != "s3cret" || vars["B"] != "pre-hunter2" || vars["C"] != "${OTHER}" {
+		t.Errorf("unexpected resolved answers %v", vars)
+	}
+	if masked := secrets.MaskString("pass hunter2 and s3cret"); masked != "pass ****** and ******" {
+		t.Errorf("unexpected masked string %q", masked)
+	}
+	if _, err := secrets.Resolve("${env:UPGRADER_TEST_UNSET}"); err == nil {
+		t.Error("expected error for unset environment variable")
+	}
+}
@@ -0,0 +1,101 @@
+package answers
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var regKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+//ParseDotenv parses KEY=VALUE lines. Values may be single quoted (literal), double quoted
+//(with escapes, may span lines) or bare, lines may carry an "export " prefix and # comments.
+func ParseDotenv(content []byte) (map[string]interface{}, error) {
+	variables := map[string]interface{}{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		start := lineNo
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+
+		parts := strings.SplitN(line, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if !regKey.MatchString(key) {
+			return nil, fmt.Errorf("line %d: invalid variable name '%s'", start, key)
+		}
+		if len(parts) == 1 {
+			variables[key] = ""
+			continue
+		}
+
+		raw := strings.TrimLeft(parts[1], " \t")
+		if raw == "" || (raw[0] != '"' && raw[0] != '\'') {
+			variables[key] = unquoted(raw)
+			continue
+		}
+
+		//quoted values may continue on the following lines until the closing quote.
+		quote := raw[0]
+		value, rest, closed := quoted(raw[1:], quote)
+		for !closed && scanner.Scan() {
+			lineNo++
+			var more string
+			more, rest, closed = quoted(scanner.Text(), quote)
+			value += "\n" + more
+		}
+		if !closed {
+			return nil, fmt.Errorf("line %d: unterminated quoted value for '%s'", start, key)
+		}
+		if rest = strings.TrimSpace(rest); rest != "" && !strings.HasPrefix(rest, "#") {
+			return nil, fmt.Errorf("line %d: unexpected characters after quoted value for '%s'", lineNo, key)
+		}
+		variables[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return variables, nil
+}
+
+//unquoted trims a bare value and strips a trailing comment.
+func unquoted(raw string) string {
+	if i := strings.Index(raw, " #"); i >= 0 {
+		raw = raw[:i]
+	}
+	return strings.TrimSpace(raw)
+}
+
+//quoted reads up to the closing quote, returning the value, what follows the quote and
+//whether the quote was closed on this line.
+func quoted(s string, quote byte) (string, string, bool) {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == quote {
+			return buf.String(), s[i+1:], true
+		}
+		if c == '\\' && quote == '"' && i+1 < len(s) {
+			i++
+			switch s[i] {
+			case 'n':
+				buf.WriteByte('\n')
+			case 't':
+				buf.WriteByte('\t')
+			case 'r':
+				buf.WriteByte('\r')
+			default:
+				buf.WriteByte(s[i])
+			}
+			continue
+		}
+		buf.WriteByte(c)
+	}
+	return buf.String(), "", false
+}
@@ -0,0 +1,209 @@
+package answers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+)
+
+//Mask replaces resolved secret values wherever they are printed.
+const Mask = "******"
+
+var (
+	regSecretRef     = regexp.MustCompile(`\$\{([a-z]+):([^}]*)\}`)
+	regURLCredential = regexp.MustCompile(`(://[^/\s:@]+):[^/\s@]+@`)
+)
+
+//SecretResolver looks up the value a secret reference points to.
+type SecretResolver interface {
+	Resolve(ref string) (string, error)
+}
+
+//SecretResolverFunc adapts a function to a SecretResolver.
+type SecretResolverFunc func(ref string) (string, error)
+
+//Resolve calls f(ref).
+func (f SecretResolverFunc) Resolve(ref string) (string, error) {
+	return f(ref)
+}
+
+//Secrets resolves ${scheme:ref} references in answers and remembers the resolved values so
+//they can be masked in any output. It is also a logrus hook masking them in log lines.
+type Secrets struct {
+	mu        sync.RWMutex
+	resolvers map[string]SecretResolver
+	values    []string
+}
+
+//NewSecrets returns Secrets with the built-in file and env resolvers registered.
+func NewSecrets() *Secrets {
+	s := &Secrets{resolvers: map[string]SecretResolver{}}
+	s.Register("file", SecretResolverFunc(resolveFile))
+	s.Register("env", SecretResolverFunc(resolveEnv))
+	return s
+}
+
+//Register adds the resolver for ${scheme:...} references, a nil resolver removes it.
+func (s *Secrets) Register(scheme string, resolver SecretResolver) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if resolver == nil {
+		delete(s.resolvers, scheme)
+		return
+	}
+	s.resolvers[scheme] = resolver
+}
+
+//ResolveAll returns a copy of the answers with every secret reference replaced by its value.
+func (s *Secrets) ResolveAll(vars map[string]interface{}) (map[string]interface{}, error) {
+	if vars == nil {
+		return nil, nil
+	}
+	resolved := map[string]interface{}{}
+	for k, v := range vars {
+		str, ok := v.(string)
+		if !ok {
+			resolved[k] = v
+			continue
+		}
+		value, err := s.Resolve(str)
+		if err != nil {
+			return nil, errors.Wrapf(err, "resolve answer '%s' failed", k)
+		}
+		resolved[k] = value
+	}
+	return resolved, nil
+}
+
+//Resolve replaces the secret references in a single value. References with an unknown scheme
+//are left untouched since compose files use the same ${...} syntax.
+func (s *Secrets) Resolve(value string) (string, error) {
+	var resolveErr error
+	result := regSecretRef.ReplaceAllStringFunc(value, func(match string) string {
+		parts := regSecretRef.FindStringSubmatch(match)
+		s.mu.RLock()
+		resolver, ok := s.resolvers[parts[1]]
+		s.mu.RUnlock()
+		if !ok || resolveErr != nil {
+			return match
+		}
+		secret, err := resolver.Resolve(parts[2])
+		if err != nil {
+			resolveErr = errors.Wrapf(err, "%s secret '%s'", parts[1], parts[2])
+			return match
+		}
+		s.remember(secret)
+		return secret
+	})
+	return result, resolveErr
+}
+
+//Add registers values to mask that don't come from a reference, like credentials given as flags.
+func (s *Secrets) Add(values ...string) {
+	for _, v := range values {
+		s.remember(v)
+	}
+}
+
+func (s *Secrets) remember(secret string) {
+	if secret == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.values {
+		if v == secret {
+			return
+		}
+	}
+	s.values = append(s.values, secret)
+	//mask longer values first so a secret containing another one is not partially revealed.
+	sort.Slice(s.values, func(i, j int) bool { return len(s.values[i]) > len(s.values[j]) })
+}
+
+//MaskString hides every resolved secret value and any password embedded in a URL in text.
+func (s *Secrets) MaskString(text string) string {
+	if s == nil {
+		return text
+	}
+	text = regURLCredential.ReplaceAllString(text, "$1:"+Mask+"@")
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, v := range s.values {
+		text = strings.Replace(text, v, Mask, -1)
+	}
+	return text
+}
+
+//Levels implements logrus.Hook.
+func (s *Secrets) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+//Fire implements logrus.Hook.
+func (s *Secrets) Fire(entry *logrus.Entry) error {
+	entry.Message = s.MaskString(entry.Message)
+	for k, v := range entry.Data {
+		str := fmt.Sprint(v)
+		if masked := s.MaskString(str); masked != str {
+			entry.Data[k] = masked
+		}
+	}
+	return nil
+}
+
+func resolveFile(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+func resolveEnv(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+//FileVault is a local stand-in for a vault server: ${vault:path#key} reads the answers file
+//at <Root>/path (dotenv, .yml or .json) and returns the value of key.
+type FileVault struct {
+	Root string
+}
+
+//Resolve implements SecretResolver.
+func (v *FileVault) Resolve(ref string) (string, error) {
+	parts := strings.SplitN(ref, "#", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("vault reference '%s' needs the form 'path#key'", ref)
+	}
+	file := filepath.Join(v.Root, filepath.Clean("/"+parts[0]))
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		for _, ext := range []string{".yml", ".yaml", ".json", ".env"} {
+			if _, err := os.Stat(file + ext); err == nil {
+				file += ext
+				break
+			}
+		}
+	}
+	vars, err := LoadFile(file)
+	if err != nil {
+		return "", err
+	}
+	value, ok := vars[parts[1]]
+	if !ok {
+		return "", fmt.Errorf("key '%s' not found in vault path '%s'", parts[1], parts[0])
+	}
+	return fmt.Sprint(value), nil
+}
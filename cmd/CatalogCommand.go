@@ -1,10 +1,17 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/rancher-upgrader/answers"
+	"github.com/rancher/rancher-upgrader/git"
 	"github.com/rancher/rancher-upgrader/model"
 	"github.com/rancher/rancher-upgrader/service"
 	"github.com/urfave/cli"
@@ -27,6 +34,31 @@ func CatalogCommand() cli.Command {
 			Usage:  "Environment SECRET KEY",
 			EnvVar: "CATTLE_SECRET_KEY",
 		},
+	}
+	catalogFlags = append(catalogFlags, catalogRepoFlags()...)
+	catalogFlags = append(catalogFlags, catalogTemplateFlags()...)
+	catalogFlags = append(catalogFlags, catalogPublishFlags()...)
+
+	return cli.Command{
+		Name:   "catalog",
+		Usage:  "upgrade catalog",
+		Action: upgradeCatalog,
+		Flags:  catalogFlags,
+		Subcommands: []cli.Command{
+			catalogCacheCommand(),
+			catalogValidateCommand(),
+			catalogListCommand(),
+			catalogShowCommand(),
+			catalogDeprecateCommand(),
+			catalogPruneCommand(),
+			catalogInitCommand(),
+		},
+	}
+}
+
+//catalogRepoFlags select the catalog repo and how it is cached and accessed.
+func catalogRepoFlags() []cli.Flag {
+	return []cli.Flag{
 		cli.StringFlag{
 			Name:  "repourl",
 			Usage: "git url for catalog repo",
@@ -40,13 +72,50 @@ func CatalogCommand() cli.Command {
 			Usage: "git username",
 		},
 		cli.StringFlag{
-			Name:  "password",
-			Usage: "git password",
+			Name:   "password",
+			Usage:  "git password",
+			EnvVar: "GIT_PASSWORD",
+		},
+		cli.StringFlag{
+			Name:   "token",
+			Usage:  "git access token, used instead of a password",
+			EnvVar: "GIT_TOKEN",
+		},
+		cli.StringFlag{
+			Name:  "ssh-key",
+			Usage: "private key file for ssh catalog repo urls",
+		},
+		cli.StringFlag{
+			Name:  "ssh-known-hosts",
+			Usage: "known hosts file to verify the ssh host key against",
+		},
+		cli.StringFlag{
+			Name:  "credential-helper",
+			Usage: "git credential helper to get the password from, e.g. store or '!pass-helper'",
 		},
 		cli.StringFlag{
 			Name:  "cacheroot",
 			Usage: "cache directory to store catalog items",
 		},
+		cli.StringFlag{
+			Name:  "git",
+			Usage: "git implementation, native (built in) or exec (runs the git binary)",
+			Value: "native",
+		},
+		cli.StringFlag{
+			Name:  "git-author-name",
+			Usage: "name new catalog commits are made with",
+		},
+		cli.StringFlag{
+			Name:  "git-author-email",
+			Usage: "email new catalog commits are made with",
+		},
+	}
+}
+
+//catalogTemplateFlags select the template and the content of its new version.
+func catalogTemplateFlags() []cli.Flag {
+	return []cli.Flag{
 		cli.StringFlag{
 			Name:  "foldername",
 			Usage: "catalog template folder name",
@@ -55,6 +124,16 @@ func CatalogCommand() cli.Command {
 			Name:  "system",
 			Usage: "catalog template type",
 		},
+		catalogTemplateBaseFlag,
+		cli.StringFlag{
+			Name:  "layout",
+			Usage: "catalog repo layout, rancher (<base>-templates/<template>/<n>) or helm (charts/<chart>/<version>)",
+			Value: "rancher",
+		},
+		cli.StringFlag{
+			Name:  "chart-dir",
+			Usage: "chart directory to add as new version with the helm layout",
+		},
 		cli.StringFlag{
 			Name:  "compose-file",
 			Usage: "docker-compose file path",
@@ -69,45 +148,489 @@ func CatalogCommand() cli.Command {
 			Name:  "readme",
 			Usage: "readme file path",
 		},
+		cli.BoolFlag{
+			Name:  "from-previous",
+			Usage: "start from the files of the latest version instead of copying the given ones",
+		},
+		cli.StringSliceFlag{
+			Name:  "set",
+			Usage: "variable for --from-previous, image=<repo>:<tag>, version=<version> or <question>=<default>",
+		},
 	}
+}
 
+//catalogPublishFlags select how changes to the catalog repo are published.
+func catalogPublishFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:  "source",
+			Usage: "source build the version comes from, recorded in the commit message",
+		},
+		cli.StringFlag{
+			Name:  "forge",
+			Usage: "open a pull request on github, gitlab or gitea for the pushed feature branch",
+		},
+		cli.StringFlag{
+			Name:  "forge-url",
+			Usage: "forge API server URL, defaults to the public GitHub/GitLab",
+		},
+		cli.StringFlag{
+			Name:   "forge-token",
+			Usage:  "forge API token",
+			EnvVar: "FORGE_TOKEN",
+		},
+		cli.StringFlag{
+			Name:  "pr-branch",
+			Usage: "feature branch the change is pushed to",
+		},
+		cli.BoolFlag{
+			Name:  "push-to-branch",
+			Usage: "push the change straight to the catalog branch instead of a feature branch",
+		},
+	}
+}
+
+func catalogCacheCommand() cli.Command {
 	return cli.Command{
-		Name:   "catalog",
-		Usage:  "upgrade catalog",
-		Action: upgradeCatalog,
-		Flags:  catalogFlags,
+		Name:  "cache",
+		Usage: "manage the catalog repo cache",
+		Subcommands: []cli.Command{
+			{
+				Name:   "prune",
+				Usage:  "remove cached catalog repos",
+				Action: pruneCatalogCache,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "cacheroot",
+						Usage: "cache directory to store catalog items",
+					},
+					cli.DurationFlag{
+						Name:  "older-than",
+						Usage: "only remove repos not used for this long, e.g. 168h",
+					},
+				},
+			},
+		},
+	}
+}
+
+func catalogValidateCommand() cli.Command {
+	return cli.Command{
+		Name:      "validate",
+		Usage:     "validate the versions of catalog templates in a local checkout",
+		ArgsUsage: "<template folder>...",
+		Action:    validateCatalog,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "compose-file",
+				Usage: "validate this docker-compose file as a new version of the template instead",
+			},
+			cli.StringFlag{
+				Name:  "rancher-file",
+				Usage: "validate this rancher-compose file as a new version of the template instead",
+			},
+		},
+	}
+}
+
+func validateCatalog(ctx *cli.Context) error {
+	if ctx.NArg() == 0 {
+		return errors.New("at least one template folder is required")
+	}
+	if ctx.IsSet("compose-file") || ctx.IsSet("rancher-file") {
+		if ctx.NArg() != 1 {
+			return errors.New("new version files can only be validated against one template folder")
+		}
+		dockerCompose, err := readOptionalFile(ctx.String("compose-file"))
+		if err != nil {
+			return err
+		}
+		rancherCompose, err := readOptionalFile(ctx.String("rancher-file"))
+		if err != nil {
+			return err
+		}
+		if err := service.ValidateTemplateVersion(ctx.Args().First(), -1, dockerCompose, rancherCompose); err != nil {
+			return err
+		}
+		logrus.Infof("%s: new version is valid", ctx.Args().First())
+		return nil
+	}
+
+	failed := 0
+	for _, templatePath := range ctx.Args() {
+		if err := service.ValidateTemplate(templatePath); err != nil {
+			logrus.Errorf("%s: %v", templatePath, err)
+			failed++
+			continue
+		}
+		logrus.Infof("%s: valid", templatePath)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d templates are invalid", failed, ctx.NArg())
+	}
+	return nil
+}
+
+func catalogListCommand() cli.Command {
+	return cli.Command{
+		Name:   "list",
+		Usage:  "list the templates of the catalog repo and their versions",
+		Action: listCatalog,
+		Flags:  catalogRepoFlags(),
+	}
+}
+
+func catalogShowCommand() cli.Command {
+	return cli.Command{
+		Name:      "show",
+		Usage:     "show a template or the files of one of its versions",
+		ArgsUsage: "<template>[:<version>]",
+		Action:    showCatalog,
+		Flags:     append(catalogRepoFlags(), catalogSystemFlag, catalogTemplateBaseFlag),
+	}
+}
+
+func catalogDeprecateCommand() cli.Command {
+	return cli.Command{
+		Name:      "deprecate",
+		Usage:     "hide old template versions or cap the rancher version they deploy on",
+		ArgsUsage: "<template> [<version>...]",
+		Action:    deprecateCatalog,
+		Flags: append(append(catalogRepoFlags(), catalogPublishFlags()...),
+			catalogSystemFlag,
+			catalogTemplateBaseFlag,
+			cli.IntFlag{
+				Name:  "before",
+				Usage: "deprecate every version folder below this one",
+				Value: -1,
+			},
+			cli.BoolFlag{
+				Name:  "hide",
+				Usage: "hide the versions from the catalog",
+			},
+			cli.StringFlag{
+				Name:  "max-rancher-version",
+				Usage: "set maximum_rancher_version of the versions, e.g. v1.6.99",
+			},
+		),
+	}
+}
+
+func catalogPruneCommand() cli.Command {
+	return cli.Command{
+		Name:      "prune",
+		Usage:     "remove old template versions no stack is deployed from",
+		ArgsUsage: "<template>",
+		Action:    pruneCatalog,
+		Flags: append(append(append(catalogRepoFlags(), catalogPublishFlags()...), connectionFlags()...),
+			catalogSystemFlag,
+			catalogTemplateBaseFlag,
+			cli.IntFlag{
+				Name:  "keep",
+				Usage: "number of newest versions to keep",
+				Value: 5,
+			},
+			cli.StringFlag{
+				Name:  "catalog-name",
+				Usage: "name the catalog repo is added to rancher as, stacks of other catalogs are ignored",
+			},
+		),
+	}
+}
+
+func catalogInitCommand() cli.Command {
+	return cli.Command{
+		Name:      "init",
+		Usage:     "create a new template with its first version",
+		ArgsUsage: "<template>",
+		Action:    initCatalog,
+		Flags: append(append(catalogRepoFlags(), catalogPublishFlags()...),
+			catalogSystemFlag,
+			catalogTemplateBaseFlag,
+			cli.StringFlag{
+				Name:  "compose-file",
+				Usage: "docker-compose file of the first version",
+				Value: "./docker-compose.yml",
+			},
+			cli.StringFlag{
+				Name:  "rancher-file",
+				Usage: "rancher-compose file of the first version, a .catalog section is added if missing",
+			},
+			cli.StringFlag{
+				Name:  "readme",
+				Usage: "readme file path, generated from the name and description otherwise",
+			},
+			cli.StringFlag{
+				Name:  "icon",
+				Usage: "icon file (svg or png), a default icon is used otherwise",
+			},
+			cli.StringFlag{
+				Name:  "name",
+				Usage: "display name of the template, defaults to the template folder",
+			},
+			cli.StringFlag{
+				Name:  "description",
+				Usage: "template description",
+			},
+			cli.StringFlag{
+				Name:  "category",
+				Usage: "template category",
+			},
+			cli.StringFlag{
+				Name:  "maintainer",
+				Usage: "template maintainer",
+			},
+			cli.StringFlag{
+				Name:  "license",
+				Usage: "template license",
+			},
+			cli.StringFlag{
+				Name:  "project-url",
+				Usage: "URL of the project the template deploys",
+			},
+		),
 	}
 }
 
+var catalogSystemFlag = cli.BoolFlag{
+	Name:  "system",
+	Usage: "the template is an infra template",
+}
+
+var catalogTemplateBaseFlag = cli.StringFlag{
+	Name:  "template-base",
+	Usage: "base of the template, e.g. kubernetes for kubernetes-templates, looked up when not given",
+}
+
+//catalogTemplateConfig sets up the catalog repo config for the <template>[:<version>] argument,
+//returning the version if one is given. Templates may be given as <base>*<template>.
+func catalogTemplateConfig(ctx *cli.Context) (*model.CatalogUpgrade, string, error) {
+	if ctx.NArg() == 0 {
+		return nil, "", errors.New("a template is required")
+	}
+	config, err := catalogRepoConfig(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	template := ctx.Args().First()
+	version := ""
+	if i := strings.Index(template, ":"); i >= 0 {
+		template, version = template[:i], template[i+1:]
+	}
+	config.TemplateIsSystem = ctx.Bool("system")
+	config.TemplateBase = ctx.String("template-base")
+	config.TemplateFolderName = template
+	return config, version, nil
+}
+
+func initCatalog(ctx *cli.Context) error {
+	config, _, err := catalogTemplateConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if config.DockerCompose, err = readOptionalFile(ctx.String("compose-file")); err != nil {
+		return err
+	}
+	if config.RancherCompose, err = readOptionalFile(ctx.String("rancher-file")); err != nil {
+		return err
+	}
+	if config.Readme, err = readOptionalFile(ctx.String("readme")); err != nil {
+		return err
+	}
+	templateConfig := &model.TemplateConfig{
+		Name:        ctx.String("name"),
+		Description: ctx.String("description"),
+		Category:    ctx.String("category"),
+		Maintainer:  ctx.String("maintainer"),
+		License:     ctx.String("license"),
+		ProjectURL:  ctx.String("project-url"),
+	}
+	return service.InitTemplate(config, templateConfig, ctx.String("icon"))
+}
+
+func listCatalog(ctx *cli.Context) error {
+	config, err := catalogRepoConfig(ctx)
+	if err != nil {
+		return err
+	}
+	templates, err := service.ListTemplates(config)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TEMPLATE\tNAME\tVERSIONS\tLATEST")
+	for _, t := range templates {
+		latest := ""
+		if len(t.Versions) > 0 {
+			v := t.Versions[len(t.Versions)-1]
+			latest = fmt.Sprintf("%d (%s)", v.Folder, v.Version)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", t.Id(), t.Name, len(t.Versions), latest)
+	}
+	return w.Flush()
+}
+
+func showCatalog(ctx *cli.Context) error {
+	config, version, err := catalogTemplateConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if version != "" {
+		files, err := service.ShowTemplateVersion(config, version)
+		if err != nil {
+			return err
+		}
+		var names []string
+		for name := range files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("==> %s <==\n%s\n", name, files[name])
+		}
+		return nil
+	}
+
+	t, err := service.ShowTemplate(config)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Template: %s\nName:     %s\n\n", t.Id(), t.Name)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FOLDER\tVERSION\tHIDDEN\tMAX RANCHER VERSION")
+	for _, v := range t.Versions {
+		fmt.Fprintf(w, "%d\t%s\t%t\t%s\n", v.Folder, v.Version, v.Hidden, v.MaximumRancherVersion)
+	}
+	return w.Flush()
+}
+
+func deprecateCatalog(ctx *cli.Context) error {
+	config, _, err := catalogTemplateConfig(ctx)
+	if err != nil {
+		return err
+	}
+	var folders []int
+	for _, arg := range ctx.Args().Tail() {
+		folder, err := strconv.Atoi(arg)
+		if err != nil {
+			return fmt.Errorf("invalid version folder '%s'", arg)
+		}
+		folders = append(folders, folder)
+	}
+	if before := ctx.Int("before"); before >= 0 {
+		t, err := service.ShowTemplate(config)
+		if err != nil {
+			return err
+		}
+		for _, v := range t.Versions {
+			if v.Folder < before {
+				folders = append(folders, v.Folder)
+			}
+		}
+	}
+	return service.DeprecateVersions(config, folders, ctx.Bool("hide"), ctx.String("max-rancher-version"))
+}
+
+func pruneCatalog(ctx *cli.Context) error {
+	config, _, err := catalogTemplateConfig(ctx)
+	if err != nil {
+		return err
+	}
+	config.CatalogName = ctx.String("catalog-name")
+	conn, err := newConnection(ctx)
+	if err != nil {
+		return err
+	}
+	envs, err := conn.resolve()
+	if err != nil {
+		return fmt.Errorf("the environments are required to check which versions stacks are deployed from: %v", err)
+	}
+	var apis []service.RancherAPI
+	for _, env := range envs {
+		api, err := conn.client(env)
+		if err != nil {
+			return err
+		}
+		apis = append(apis, api)
+	}
+	pruned, err := service.PruneVersions(config, apis, ctx.Int("keep"))
+	if err != nil {
+		return err
+	}
+	for _, folder := range pruned {
+		logrus.Infof("removed version %d of %s", folder, config.TemplateFolderName)
+	}
+	return nil
+}
+
+func pruneCatalogCache(ctx *cli.Context) error {
+	if ctx.String("cacheroot") == "" {
+		return errors.New("--cacheroot is required")
+	}
+	pruned, err := service.PruneCache(ctx.String("cacheroot"), ctx.Duration("older-than"))
+	for _, p := range pruned {
+		logrus.Infof("removed %s", p)
+	}
+	return err
+}
+
+//catalogRepoConfig sets up git access to the catalog repo from the catalogRepoFlags and
+//catalogPublishFlags of ctx.
+func catalogRepoConfig(ctx *cli.Context) (*model.CatalogUpgrade, error) {
+	secrets := commandSecrets()
+
+	repoUrl, user, password := git.SplitURLCredentials(ctx.String("repourl"))
+	if ctx.String("user") != "" {
+		user = ctx.String("user")
+	}
+	if ctx.String("password") != "" {
+		password = ctx.String("password")
+	}
+	if ctx.String("token") != "" {
+		password = ctx.String("token")
+	}
+	auth := git.Auth{
+		Username:         user,
+		Password:         password,
+		SSHKeyFile:       ctx.String("ssh-key"),
+		KnownHostsFile:   ctx.String("ssh-known-hosts"),
+		CredentialHelper: ctx.String("credential-helper"),
+	}
+	if err := auth.FillFromHelper(repoUrl); err != nil {
+		return nil, err
+	}
+	secrets.Add(auth.Password, ctx.String("forge-token"))
+
+	g, err := git.New(ctx.String("git"), auth, git.Author{
+		Name:  ctx.String("git-author-name"),
+		Email: ctx.String("git-author-email"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	git.Default = g
+
+	return &model.CatalogUpgrade{
+		CacheRoot:         ctx.String("cacheroot"),
+		GitUrl:            repoUrl,
+		GitBranch:         ctx.String("branch"),
+		SourceBuild:       ctx.String("source"),
+		Forge:             ctx.String("forge"),
+		ForgeUrl:          ctx.String("forge-url"),
+		ForgeToken:        ctx.String("forge-token"),
+		PullRequestBranch: ctx.String("pr-branch"),
+		PushToBranch:      ctx.Bool("push-to-branch"),
+	}, nil
+}
+
 func upgradeCatalog(ctx *cli.Context) error {
-	//factory := ClientFactory{}
-	//apiClient, _ := factory.GetClient(ctx)
+	config, err := catalogRepoConfig(ctx)
+	if err != nil {
+		return err
+	}
 
-	composeFile := ctx.String("compose-file")
-	rancherFile := ctx.String("rancher-file")
-	dockerCompose := ""
-	rancherCompose := ""
-	if composeFile != "" {
-		cdat, err := ioutil.ReadFile(composeFile)
-		check(err)
-		dockerCompose = string(cdat)
-	}
-	if rancherFile != "" {
-		rdat, err := ioutil.ReadFile(rancherFile)
-		check(err)
-		rancherCompose = string(rdat)
-	}
-	config := &model.CatalogUpgrade{
-		CacheRoot:          ctx.String("cacheroot"),
-		GitUrl:             ctx.String("repourl"),
-		GitBranch:          ctx.String("branch"),
-		TemplateFolderName: ctx.String("foldername"),
-		TemplateIsSystem:   ctx.Bool("system"),
-		GitUser:            ctx.String("user"),
-		GitPassword:        ctx.String("password"),
-
-		DockerCompose:  dockerCompose,
-		RancherCompose: rancherCompose,
+	if err := catalogVersionConfig(ctx, config); err != nil {
+		return err
 	}
 	if err := service.UpgradeCatalog(config); err != nil {
 		return err
@@ -115,9 +638,44 @@ func upgradeCatalog(ctx *cli.Context) error {
 	return nil
 }
 
-func check(e error) {
-	if e != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v", e)
-		os.Exit(1)
+//catalogVersionConfig sets the template and the content of its new version from the
+//catalogTemplateFlags of ctx.
+func catalogVersionConfig(ctx *cli.Context, config *model.CatalogUpgrade) error {
+	fromPrevious := ctx.Bool("from-previous")
+	composeFile := ctx.String("compose-file")
+	rancherFile := ctx.String("rancher-file")
+	if fromPrevious && !ctx.IsSet("compose-file") {
+		composeFile = ""
+	}
+	if fromPrevious && !ctx.IsSet("rancher-file") {
+		rancherFile = ""
 	}
+	variables := map[string]string{}
+	sets, err := answers.Load(nil, ctx.StringSlice("set"))
+	if err != nil {
+		return err
+	}
+	for k, v := range sets {
+		variables[k] = v.(string)
+	}
+	if config.DockerCompose, err = readOptionalFile(composeFile); err != nil {
+		return err
+	}
+	if config.RancherCompose, err = readOptionalFile(rancherFile); err != nil {
+		return err
+	}
+	if config.Readme, err = readOptionalFile(ctx.String("readme")); err != nil {
+		return err
+	}
+	config.TemplateFolderName = ctx.String("foldername")
+	config.TemplateIsSystem = ctx.Bool("system")
+	config.TemplateBase = ctx.String("template-base")
+	config.Layout = ctx.String("layout")
+	config.ChartDir = ctx.String("chart-dir")
+	if config.Layout != service.LayoutRancher && config.Layout != service.LayoutHelm {
+		return fmt.Errorf("unknown catalog layout '%s'", config.Layout)
+	}
+	config.FromPrevious = fromPrevious
+	config.Variables = variables
+	return nil
 }
@@ -0,0 +1,229 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/rancher-upgrader/profile"
+	"github.com/urfave/cli"
+)
+
+func ConfigCommand() cli.Command {
+	return cli.Command{
+		Name:  "config",
+		Usage: "manage the connection profiles of the config file",
+		Subcommands: []cli.Command{
+			{
+				Name:      "add",
+				Usage:     "add a profile or replace the one with the same name",
+				ArgsUsage: "<name>",
+				Action:    addProfile,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "url",
+						Usage: "rancher server URL",
+					},
+					cli.StringFlag{
+						Name:  "environment",
+						Usage: "environment name or ID on --url",
+					},
+					cli.StringFlag{
+						Name:  "envurl",
+						Usage: "Environment ENDPOINT URL, instead of --url and --environment",
+					},
+					cli.StringFlag{
+						Name:  "accesskey",
+						Usage: "ACCESS KEY",
+					},
+					cli.StringFlag{
+						Name:  "secretkey",
+						Usage: "SECRET KEY",
+					},
+					cli.DurationFlag{
+						Name:  "timeout",
+						Usage: "timeout of rancher API requests",
+					},
+					cli.DurationFlag{
+						Name:  "wait-timeout",
+						Usage: "how long to wait for an upgrade to finish",
+					},
+					cli.StringFlag{
+						Name:  "ca-cert",
+						Usage: "CA certificate (PEM) the rancher server certificate is signed with",
+					},
+					cli.StringFlag{
+						Name:  "client-cert",
+						Usage: "client certificate (PEM) to authenticate with",
+					},
+					cli.StringFlag{
+						Name:  "client-key",
+						Usage: "key (PEM) of the client certificate",
+					},
+					cli.BoolFlag{
+						Name:  "insecure-skip-verify",
+						Usage: "don't verify the TLS certificate of the rancher server",
+					},
+					cli.StringFlag{
+						Name:  "proxy",
+						Usage: "HTTP(S) proxy URL",
+					},
+					cli.BoolFlag{
+						Name:  "use",
+						Usage: "make it the current profile",
+					},
+				},
+			},
+			{
+				Name:   "list",
+				Usage:  "list the profiles",
+				Action: listProfiles,
+			},
+			{
+				Name:      "use",
+				Usage:     "make a profile the current one",
+				ArgsUsage: "<name>",
+				Action:    useProfile,
+			},
+			{
+				Name:      "remove",
+				Usage:     "remove a profile",
+				ArgsUsage: "<name>",
+				Action:    removeProfile,
+			},
+		},
+	}
+}
+
+//updateConfig loads the config file, lets f change it and saves it.
+func updateConfig(f func(config *profile.Config) error) error {
+	path, err := profile.DefaultPath()
+	if err != nil {
+		return err
+	}
+	config, err := profile.Load(path)
+	if err != nil {
+		return err
+	}
+	if err := f(config); err != nil {
+		return err
+	}
+	return config.Save(path)
+}
+
+func profileName(ctx *cli.Context) (string, error) {
+	if ctx.NArg() != 1 {
+		return "", errors.New("a profile name is required")
+	}
+	return ctx.Args().First(), nil
+}
+
+func addProfile(ctx *cli.Context) error {
+	name, err := profileName(ctx)
+	if err != nil {
+		return err
+	}
+	p := &profile.Profile{
+		Url:         ctx.String("url"),
+		Environment: ctx.String("environment"),
+		EnvUrl:      ctx.String("envurl"),
+		AccessKey:   ctx.String("accesskey"),
+		SecretKey:   ctx.String("secretkey"),
+		Timeout:     ctx.Duration("timeout"),
+		WaitTimeout: ctx.Duration("wait-timeout"),
+
+		CACert:             absPath(ctx.String("ca-cert")),
+		ClientCert:         absPath(ctx.String("client-cert")),
+		ClientKey:          absPath(ctx.String("client-key")),
+		InsecureSkipVerify: ctx.Bool("insecure-skip-verify"),
+		Proxy:              ctx.String("proxy"),
+	}
+	if (p.ClientCert == "") != (p.ClientKey == "") {
+		return errors.New("--client-cert and --client-key go together")
+	}
+	if (p.Url == "") == (p.EnvUrl == "") {
+		return errors.New("either --url or --envurl is required")
+	}
+	if p.Url != "" && p.Environment == "" {
+		return errors.New("--environment is required with --url")
+	}
+	return updateConfig(func(config *profile.Config) error {
+		config.Profiles[name] = p
+		if ctx.Bool("use") || config.Current == "" {
+			config.Current = name
+		}
+		logrus.Infof("profile %s saved, current profile is %s", name, config.Current)
+		return nil
+	})
+}
+
+//absPath keeps the files of a profile usable from any directory.
+func absPath(file string) string {
+	if file == "" {
+		return ""
+	}
+	if abs, err := filepath.Abs(file); err == nil {
+		return abs
+	}
+	return file
+}
+
+func listProfiles(ctx *cli.Context) error {
+	path, err := profile.DefaultPath()
+	if err != nil {
+		return err
+	}
+	config, err := profile.Load(path)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CURRENT\tNAME\tENVIRONMENT\tACCESS KEY")
+	for _, name := range config.Names() {
+		p := config.Profiles[name]
+		current := ""
+		if name == config.Current {
+			current = "*"
+		}
+		env := p.EnvUrl
+		if p.Url != "" {
+			env = fmt.Sprintf("%s on %s", p.Environment, p.Url)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", current, name, env, p.AccessKey)
+	}
+	return w.Flush()
+}
+
+func useProfile(ctx *cli.Context) error {
+	name, err := profileName(ctx)
+	if err != nil {
+		return err
+	}
+	return updateConfig(func(config *profile.Config) error {
+		if _, err := config.Get(name); err != nil {
+			return err
+		}
+		config.Current = name
+		return nil
+	})
+}
+
+func removeProfile(ctx *cli.Context) error {
+	name, err := profileName(ctx)
+	if err != nil {
+		return err
+	}
+	return updateConfig(func(config *profile.Config) error {
+		if _, err := config.Get(name); err != nil {
+			return err
+		}
+		delete(config.Profiles, name)
+		if config.Current == name {
+			config.Current = ""
+		}
+		return nil
+	})
+}
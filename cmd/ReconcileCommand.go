@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/rancher-upgrader/answers"
+	"github.com/rancher/rancher-upgrader/model"
+	"github.com/rancher/rancher-upgrader/service"
+	"github.com/urfave/cli"
+)
+
+func ReconcileCommand() cli.Command {
+	reconcileFlags := append(environmentFlags(),
+		cli.StringFlag{
+			Name:  "dir",
+			Usage: "desired-state directory, <dir>/<environment>/<stack>/ or <dir>/<stack>/ with --envurl",
+		},
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "only report drift, fails when a stack drifted or is missing",
+		},
+		cli.DurationFlag{
+			Name:  "interval",
+			Usage: "reconcile again after this long until interrupted, once without it",
+		},
+		cli.StringFlag{
+			Name:  "vault-dir",
+			Usage: "directory backing ${vault:path#key} secret references",
+		},
+	)
+
+	return cli.Command{
+		Name:   "reconcile",
+		Usage:  "bring the stacks of environments to the state declared in a directory",
+		Action: reconcile,
+		Flags:  reconcileFlags,
+	}
+}
+
+func reconcile(ctx *cli.Context) error {
+	dir := ctx.String("dir")
+	if dir == "" {
+		return errors.New("--dir is required")
+	}
+	conn, err := newConnection(ctx)
+	if err != nil {
+		return err
+	}
+	if conn.url != "" && len(conn.environments) == 0 {
+		if conn.environments, err = subdirectories(dir); err != nil {
+			return err
+		}
+	}
+	config := &model.StackUpgrade{
+		Secrets:     stackSecrets(ctx),
+		WaitTimeout: conn.waitTimeout,
+	}
+
+	once := func() error {
+		var mu sync.Mutex
+		var drifted int
+		report := map[string][]service.StackDrift{}
+		err := runInEnvironments(ctx, conn, func(env service.EnvTarget, api service.RancherAPI) error {
+			//environments given by endpoint URL have no name to look their folder up by.
+			stackDir := dir
+			if env.Name != env.Url {
+				stackDir = filepath.Join(dir, env.Name)
+			}
+			desired, err := service.LoadDesiredStacks(stackDir)
+			if err != nil {
+				return err
+			}
+			drifts, err := service.ReconcileStacks(api, desired, config, !ctx.Bool("dry-run"))
+			mu.Lock()
+			defer mu.Unlock()
+			report[env.Name] = drifts
+			for _, d := range drifts {
+				if d.State == service.StackDrifted || d.State == service.StackMissing {
+					drifted++
+				}
+			}
+			return err
+		})
+		printDrift(report, config.Secrets)
+		if err == nil && drifted > 0 {
+			err = fmt.Errorf("%d stacks drift from the desired state", drifted)
+		}
+		return err
+	}
+	if ctx.Duration("interval") <= 0 {
+		return once()
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	for {
+		if err := once(); err != nil {
+			logrus.Errorf("reconcile: %v", err)
+		}
+		select {
+		case <-time.After(ctx.Duration("interval")):
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+//subdirectories lists the environment folders of the desired-state directory.
+func subdirectories(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, f := range files {
+		if f.IsDir() && !strings.HasPrefix(f.Name(), ".") {
+			names = append(names, f.Name())
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%s has no environment folders", dir)
+	}
+	return names, nil
+}
+
+//printDrift lists the stacks of every environment with their differences from the desired state.
+func printDrift(report map[string][]service.StackDrift, secrets *answers.Secrets) {
+	var envs []string
+	for env := range report {
+		envs = append(envs, env)
+	}
+	sort.Strings(envs)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ENVIRONMENT\tSTACK\tSTATE\tDETAILS")
+	for _, env := range envs {
+		for _, d := range report[env] {
+			details := d.Differences
+			if d.Err != nil {
+				details = append([]string{secrets.MaskString(d.Err.Error())}, details...)
+			}
+			if len(details) == 0 {
+				details = []string{""}
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", env, d.Stack, d.State, details[0])
+			for _, detail := range details[1:] {
+				fmt.Fprintf(w, "\t\t\t%s\n", detail)
+			}
+		}
+	}
+	w.Flush()
+}
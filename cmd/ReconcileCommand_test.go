@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/go-rancher/v2"
+	"github.com/rancher/rancher-upgrader/ranchertest"
+)
+
+func TestReconcileCommand(t *testing.T) {
+	s := ranchertest.NewServer()
+	defer s.Close()
+	s.AddStack(client.Stack{Name: "web", DockerCompose: "version: '2'\nservices:\n  web:\n    image: web:1\n"})
+
+	dir, err := ioutil.TempDir("", "desired")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "web"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	compose := "version: '2'\nservices:\n  web:\n    image: web:2\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "web", "docker-compose.yml"), []byte(compose), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := run(t, "reconcile", "--envurl", s.EnvUrl(), "--dir", dir, "--dry-run"); err == nil {
+		t.Error("expected the drifted stack to fail the dry run")
+	}
+	if web, _ := s.Stack("web"); web.DockerCompose == compose {
+		t.Error("a dry run must not upgrade the stack")
+	}
+	if err := run(t, "reconcile", "--envurl", s.EnvUrl(), "--dir", dir); err != nil {
+		t.Fatal(err)
+	}
+	if web, _ := s.Stack("web"); web.DockerCompose != compose || web.State != "active" {
+		t.Errorf("expected web active with the desired compose file, got %s %q", web.State, web.DockerCompose)
+	}
+	if err := run(t, "reconcile", "--envurl", s.EnvUrl(), "--dir", dir, "--dry-run"); err != nil {
+		t.Errorf("expected no drift after reconciling, got %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/rancher/rancher-upgrader/answers"
+	"github.com/rancher/rancher-upgrader/model"
+	"github.com/rancher/rancher-upgrader/service"
+	"github.com/urfave/cli"
+)
+
+func ReleaseCommand() cli.Command {
+	releaseFlags := append(connectionFlags(),
+		cli.StringFlag{
+			Name:  "catalog-name",
+			Usage: "name the catalog repo is added to rancher as",
+		},
+		cli.StringFlag{
+			Name:  "source",
+			Usage: "source build the version comes from, recorded in the commit message",
+		},
+		cli.StringSliceFlag{
+			Name:  "stackname",
+			Usage: "stack to upgrade, may be repeated, defaults to every stack deployed from the template",
+		},
+		cli.StringSliceFlag{
+			Name:  "env-file",
+			Usage: "answers file applied to the stacks (dotenv, .yml or .json), may be repeated",
+		},
+		cli.StringSliceFlag{
+			Name:  "answer",
+			Usage: "answer override in the form KEY=VALUE, may be repeated",
+		},
+		cli.StringFlag{
+			Name:  "vault-dir",
+			Usage: "directory backing ${vault:path#key} secret references",
+		},
+		cli.IntFlag{
+			Name:  "parallelism",
+			Usage: "number of stacks upgraded at once",
+			Value: 1,
+		},
+		cli.DurationFlag{
+			Name:  "wait-timeout",
+			Usage: "how long to wait for the catalog to serve the new version",
+			Value: 5 * time.Minute,
+		},
+		cli.DurationFlag{
+			Name:  "upgrade-timeout",
+			Usage: "how long to wait for a stack upgrade to finish",
+			Value: 3 * time.Minute,
+		},
+	)
+	releaseFlags = append(releaseFlags, catalogRepoFlags()...)
+	releaseFlags = append(releaseFlags, catalogTemplateFlags()...)
+
+	return cli.Command{
+		Name:   "release",
+		Usage:  "publish a catalog template version and upgrade stacks to it",
+		Action: release,
+		Flags:  releaseFlags,
+	}
+}
+
+func release(ctx *cli.Context) error {
+	conn, err := newConnection(ctx)
+	if err != nil {
+		return err
+	}
+	_, api, err := conn.singleEnvironment()
+	if err != nil {
+		return err
+	}
+
+	catalogConfig, err := catalogRepoConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if err := catalogVersionConfig(ctx, catalogConfig); err != nil {
+		return err
+	}
+	catalogConfig.CatalogName = ctx.String("catalog-name")
+
+	var envs map[string]interface{}
+	if len(ctx.StringSlice("env-file")) > 0 || len(ctx.StringSlice("answer")) > 0 {
+		envs, err = answers.Load(ctx.StringSlice("env-file"), ctx.StringSlice("answer"))
+		if err != nil {
+			return err
+		}
+	}
+	stackConfig := &model.StackUpgrade{
+		Environment: envs,
+		Secrets:     stackSecrets(ctx),
+		Parallelism: ctx.Int("parallelism"),
+		WaitTimeout: ctx.Duration("upgrade-timeout"),
+	}
+
+	results, err := service.Release(api, catalogConfig, stackConfig, ctx.StringSlice("stackname"), ctx.Duration("wait-timeout"))
+	logStackResults("", results)
+	return err
+}
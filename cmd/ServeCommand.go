@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/rancher-upgrader/model"
+	"github.com/rancher/rancher-upgrader/service"
+	"github.com/rancher/rancher-upgrader/webhook"
+	"github.com/urfave/cli"
+)
+
+func ServeCommand() cli.Command {
+	serveFlags := append(environmentFlags(),
+		cli.StringFlag{
+			Name:  "listen",
+			Usage: "address the webhook endpoint listens on",
+			Value: ":8080",
+		},
+		cli.StringFlag{
+			Name:   "secret",
+			Usage:  "shared secret webhooks are authenticated with, as token or HMAC signature",
+			EnvVar: "RANCHER_UPGRADER_WEBHOOK_SECRET",
+		},
+		cli.StringFlag{
+			Name:  "rules",
+			Usage: "YAML file mapping pushed repositories to service selectors",
+		},
+	)
+
+	return cli.Command{
+		Name:   "serve",
+		Usage:  "upgrade services on registry push webhooks",
+		Action: serve,
+		Flags:  serveFlags,
+	}
+}
+
+func serve(ctx *cli.Context) error {
+	if ctx.String("secret") == "" {
+		return errors.New("--secret is required to authenticate webhooks")
+	}
+	if ctx.String("rules") == "" {
+		return errors.New("--rules is required")
+	}
+	rules, err := webhook.LoadRules(ctx.String("rules"))
+	if err != nil {
+		return err
+	}
+	conn, err := newConnection(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.resolve(); err != nil {
+		return err
+	}
+
+	handler := webhook.NewHandler(ctx.String("secret"), rules, func(rule webhook.Rule, image string) error {
+		config := &model.ServiceUpgrade{
+			ServiceSelector: rule.Selector,
+			BatchSize:       rule.BatchSize,
+			IntervalMillis:  rule.Interval,
+			StartFirst:      rule.StartFirst,
+			WaitTimeout:     conn.waitTimeout,
+		}
+		if config.BatchSize < 1 {
+			config.BatchSize = 1
+		}
+		if config.IntervalMillis < 1 {
+			config.IntervalMillis = 1
+		}
+		return runInEnvironments(ctx, conn, func(env service.EnvTarget, api service.RancherAPI) error {
+			return service.UpgradeServices(api, config, image)
+		})
+	})
+	mux := http.NewServeMux()
+	mux.Handle("/webhook", handler)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	server := &http.Server{Addr: ctx.String("listen"), Handler: mux}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	done := make(chan error, 1)
+	go func() {
+		logrus.Infof("receiving webhooks on %s/webhook", ctx.String("listen"))
+		done <- server.ListenAndServe()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-stop:
+	}
+
+	logrus.Info("shutting down, waiting for running upgrades")
+	shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdown); err != nil {
+		logrus.Warnf("shutdown: %v", err)
+	}
+	handler.Wait()
+	return nil
+}
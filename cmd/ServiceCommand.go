@@ -10,22 +10,7 @@ import (
 )
 
 func ServiceCommand() cli.Command {
-	serviceFlags := []cli.Flag{
-		cli.StringFlag{
-			Name:   "envurl",
-			Usage:  "Environment ENDPOINT URL",
-			EnvVar: "CATTLE_URL",
-		},
-		cli.StringFlag{
-			Name:   "accesskey",
-			Usage:  "Environment ACCESS KEY",
-			EnvVar: "CATTLE_ACCESS_KEY",
-		},
-		cli.StringFlag{
-			Name:   "secretkey",
-			Usage:  "Environment SECRET KEY",
-			EnvVar: "CATTLE_SECRET_KEY",
-		},
+	serviceFlags := append(environmentFlags(),
 		cli.StringFlag{
 			Name:  "image",
 			Usage: "image to use",
@@ -48,7 +33,7 @@ func ServiceCommand() cli.Command {
 			Name:  "startfirst",
 			Usage: "start before stopping",
 		},
-	}
+	)
 
 	return cli.Command{
 		Name:   "service",
@@ -59,8 +44,6 @@ func ServiceCommand() cli.Command {
 }
 
 func upgrade(ctx *cli.Context) error {
-	factory := ClientFactory{}
-	apiClient, _ := factory.GetClient(ctx)
 	selectors := ctx.StringSlice("selector")
 	svcSelectors, err := envVarstoMap(selectors)
 	if err != nil {
@@ -70,15 +53,21 @@ func upgrade(ctx *cli.Context) error {
 	interval := ctx.Int64("interval")
 	startFirst := ctx.Bool("startfirst")
 	image := ctx.String("image")
+	conn, err := newConnection(ctx)
+	if err != nil {
+		return err
+	}
 
 	config := &model.ServiceUpgrade{
 		ServiceSelector: svcSelectors,
 		BatchSize:       batchSize,
 		IntervalMillis:  interval,
 		StartFirst:      startFirst,
+		WaitTimeout:     conn.waitTimeout,
 	}
-	service.UpgradeServices(apiClient, config, image)
-	return nil
+	return runInEnvironments(ctx, conn, func(env service.EnvTarget, api service.RancherAPI) error {
+		return service.UpgradeServices(api, config, image)
+	})
 }
 
 func envVarstoMap(vars []string) (map[string]string, error) {
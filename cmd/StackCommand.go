@@ -1,40 +1,29 @@
 package cmd
 
 import (
-	"bufio"
-	"os"
-	"strings"
+	"io/ioutil"
+	"sync"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/rancher/rancher-upgrader/answers"
 	"github.com/rancher/rancher-upgrader/model"
 	"github.com/rancher/rancher-upgrader/service"
 	"github.com/urfave/cli"
 )
 
 func StackCommand() cli.Command {
-	stackFlags := []cli.Flag{
-		cli.StringFlag{
-			Name:   "envurl",
-			Usage:  "Environment ENDPOINT URL",
-			EnvVar: "CATTLE_URL",
-		},
-		cli.StringFlag{
-			Name:   "accesskey",
-			Usage:  "Environment ACCESS KEY",
-			EnvVar: "CATTLE_ACCESS_KEY",
-		},
-		cli.StringFlag{
-			Name:   "secretkey",
-			Usage:  "Environment SECRET KEY",
-			EnvVar: "CATTLE_SECRET_KEY",
-		},
+	stackFlags := append(environmentFlags(),
 		cli.StringFlag{
 			Name:  "stackname",
 			Usage: "stack name to upgrade",
 		},
-		cli.StringFlag{
+		cli.StringSliceFlag{
 			Name:  "env-file",
-			Usage: "env file to use in catalog",
+			Usage: "answers file to use in catalog (dotenv, .yml or .json), may be repeated",
+		},
+		cli.StringSliceFlag{
+			Name:  "set",
+			Usage: "answer override in the form KEY=VALUE, may be repeated",
 		},
 		cli.StringFlag{
 			Name:  "compose-file",
@@ -44,11 +33,32 @@ func StackCommand() cli.Command {
 			Name:  "rancher-file",
 			Usage: "rancher compose file for stack upgrade",
 		},
+		cli.StringFlag{
+			Name:  "vault-dir",
+			Usage: "directory backing ${vault:path#key} secret references",
+		},
 		cli.BoolFlag{
 			Name:  "tolatest",
 			Usage: "upgrade stack to latest catalog version",
 		},
-	}
+		cli.StringFlag{
+			Name:  "external-id",
+			Usage: "catalog template version to install or upgrade to, e.g. catalog://library:nginx:2",
+		},
+		cli.StringFlag{
+			Name:  "template",
+			Usage: "upgrade every stack deployed from this catalog template, e.g. library:infra*ipsec",
+		},
+		cli.IntFlag{
+			Name:  "parallelism",
+			Usage: "number of stacks upgraded at once with --template",
+			Value: 1,
+		},
+		cli.BoolFlag{
+			Name:  "install-if-missing",
+			Usage: "create the stack if no stack with the given name exists",
+		},
+	)
 
 	return cli.Command{
 		Name:   "stack",
@@ -59,46 +69,86 @@ func StackCommand() cli.Command {
 }
 
 func upgradeStack(ctx *cli.Context) error {
-	factory := ClientFactory{}
-	apiClient, _ := factory.GetClient(ctx)
-
 	var envs map[string]interface{}
-	if ctx.String("env-file") != "" {
-		envs = parseCustomEnvFile(ctx.String("env-file"))
+	var err error
+	if len(ctx.StringSlice("env-file")) > 0 || len(ctx.StringSlice("set")) > 0 {
+		envs, err = answers.Load(ctx.StringSlice("env-file"), ctx.StringSlice("set"))
+		if err != nil {
+			return err
+		}
 	}
-	config := &model.StackUpgrade{
-		CattleUrl:       ctx.String("envurl"),
-		AccessKey:       ctx.String("accesskey"),
-		SecretKey:       ctx.String("secretkey"),
-		StackName:       ctx.String("stackname"),
-		Environment:     envs,
-		ToLatestCatalog: ctx.Bool("tolatest"),
+	dockerCompose, err := readOptionalFile(ctx.String("compose-file"))
+	if err != nil {
+		return err
+	}
+	rancherCompose, err := readOptionalFile(ctx.String("rancher-file"))
+	if err != nil {
+		return err
 	}
-	service.UpgradeStack(apiClient, config)
-	return nil
-}
-
-func parseCustomEnvFile(file string) map[string]interface{} {
-	variables := map[string]interface{}{}
 
-	f, err := os.Open(file)
+	conn, err := newConnection(ctx)
 	if err != nil {
-		logrus.Fatal(err)
+		return err
 	}
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		t := scanner.Text()
-		parts := strings.SplitN(t, "=", 2)
-		if len(parts) == 1 {
-			variables[parts[0]] = ""
-		} else {
-			variables[parts[0]] = parts[1]
-		}
+	secrets := stackSecrets(ctx)
+
+	config := &model.StackUpgrade{
+		StackName:        ctx.String("stackname"),
+		Environment:      envs,
+		DockerCompose:    dockerCompose,
+		RancherCompose:   rancherCompose,
+		ExternalId:       ctx.String("external-id"),
+		ToLatestCatalog:  ctx.Bool("tolatest"),
+		InstallIfMissing: ctx.Bool("install-if-missing"),
+		Secrets:          secrets,
+		Template:         ctx.String("template"),
+		Parallelism:      ctx.Int("parallelism"),
+		WaitTimeout:      conn.waitTimeout,
 	}
+	return runInEnvironments(ctx, conn, func(env service.EnvTarget, api service.RancherAPI) error {
+		envConfig := *config
+		if envConfig.Template != "" {
+			results, err := service.UpgradeTemplateStacks(api, &envConfig)
+			logStackResults(env.Name, results)
+			return err
+		}
+		return service.UpgradeStack(api, &envConfig)
+	})
+}
+
+var (
+	logSecretsOnce sync.Once
+	logSecrets     *answers.Secrets
+)
+
+//commandSecrets returns the secrets of the process. They are masked in the log by a single
+//hook, registered on first use, however many commands run in the process.
+func commandSecrets() *answers.Secrets {
+	logSecretsOnce.Do(func() {
+		logSecrets = answers.NewSecrets()
+		logrus.AddHook(logSecrets)
+	})
+	return logSecrets
+}
 
-	if scanner.Err() != nil {
-		logrus.Fatal(scanner.Err())
+//stackSecrets resolves the secret references of answers, masking them in the log.
+func stackSecrets(ctx *cli.Context) *answers.Secrets {
+	secrets := commandSecrets()
+	var vault answers.SecretResolver
+	if ctx.String("vault-dir") != "" {
+		vault = &answers.FileVault{Root: ctx.String("vault-dir")}
 	}
+	secrets.Register("vault", vault)
+	return secrets
+}
 
-	return variables
+func readOptionalFile(file string) (string, error) {
+	if file == "" {
+		return "", nil
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
 }
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/rancher/go-rancher/catalog"
+	"github.com/rancher/go-rancher/v2"
+	"github.com/rancher/rancher-upgrader/ranchertest"
+	"github.com/urfave/cli"
+)
+
+func run(t *testing.T, args ...string) error {
+	app := cli.NewApp()
+	app.Commands = []cli.Command{StackCommand(), ServiceCommand(), ReconcileCommand()}
+	return app.Run(append([]string{"rancher-upgrader"}, args...))
+}
+
+func TestStackCommand(t *testing.T) {
+	s := ranchertest.NewServer()
+	defer s.Close()
+	s.AccessKey, s.SecretKey = "ak", "sk"
+	s.AddStack(client.Stack{Name: "web", ExternalId: "catalog://lib:web:1", Environment: map[string]interface{}{"REPLICAS": "3"}})
+	s.AddTemplateVersion("lib:web:1", catalog.TemplateVersion{Files: map[string]interface{}{"docker-compose.yml": "web: {image: web:1}"}}, "lib:web:2")
+	s.AddTemplateVersion("lib:web:2", catalog.TemplateVersion{
+		Files:     map[string]interface{}{"docker-compose.yml": "web: {image: web:2}"},
+		Questions: []catalog.Question{{Variable: "REPLICAS", Type: "int", Default: "1"}, {Variable: "DEBUG", Type: "boolean", Default: "false"}},
+	})
+
+	if err := run(t, "stack", "--envurl", s.EnvUrl(), "--accesskey", "ak", "--secretkey", "sk", "--stackname", "web", "--tolatest", "--set", "DEBUG=true"); err != nil {
+		t.Fatal(err)
+	}
+	web, _ := s.Stack("web")
+	if web.ExternalId != "catalog://lib:web:2" || web.State != "active" {
+		t.Errorf("expected web active at lib:web:2, got %s %s", web.ExternalId, web.State)
+	}
+	if web.Environment["REPLICAS"] != "3" || web.Environment["DEBUG"] != "true" {
+		t.Errorf("expected previous and given answers, got %v", web.Environment)
+	}
+
+	if err := run(t, "stack", "--envurl", s.EnvUrl(), "--accesskey", "ak", "--secretkey", "wrong", "--stackname", "web", "--tolatest"); err == nil {
+		t.Error("expected wrong keys to fail")
+	}
+}
+
+func TestServiceCommand(t *testing.T) {
+	s := ranchertest.NewServer()
+	defer s.Close()
+	s.AddService(client.Service{Name: "web", LaunchConfig: &client.LaunchConfig{ImageUuid: "docker:web:1", Labels: map[string]interface{}{"app": "web"}}})
+
+	if err := run(t, "service", "--envurl", s.EnvUrl(), "--selector", "app=web", "--image", "web:2"); err != nil {
+		t.Fatal(err)
+	}
+	if web, _ := s.Service("web"); web.LaunchConfig.ImageUuid != "docker:web:2" || web.State != "active" {
+		t.Errorf("expected web active at web:2, got %s %s", web.LaunchConfig.ImageUuid, web.State)
+	}
+}
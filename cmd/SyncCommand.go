@@ -0,0 +1,224 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/rancher-upgrader/model"
+	"github.com/rancher/rancher-upgrader/service"
+	"github.com/rancher/rancher-upgrader/webhook"
+	"github.com/urfave/cli"
+)
+
+func SyncCommand() cli.Command {
+	syncFlags := append(connectionFlags(),
+		cli.StringFlag{
+			Name:  "source-url",
+			Usage: "git url of the source repo holding the compose files, accessed with the catalog repo credentials",
+		},
+		cli.StringFlag{
+			Name:  "source-branch",
+			Usage: "source repo branch",
+			Value: "master",
+		},
+		cli.StringFlag{
+			Name:  "source-path",
+			Usage: "folder of the source repo with docker-compose.yml, rancher-compose.yml and optionally README.md",
+			Value: ".",
+		},
+		cli.StringFlag{
+			Name:  "foldername",
+			Usage: "catalog template folder name",
+		},
+		catalogSystemFlag,
+		catalogTemplateBaseFlag,
+		cli.DurationFlag{
+			Name:  "interval",
+			Usage: "how often the source branch is fetched, 0 to only sync on webhooks",
+			Value: time.Minute,
+		},
+		cli.StringFlag{
+			Name:  "listen",
+			Usage: "address GitHub/GitLab push webhooks are received on, e.g. :8080",
+		},
+		cli.StringFlag{
+			Name:   "secret",
+			Usage:  "shared secret webhooks are authenticated with, as token or HMAC signature",
+			EnvVar: "RANCHER_UPGRADER_WEBHOOK_SECRET",
+		},
+		cli.BoolFlag{
+			Name:  "once",
+			Usage: "sync once and exit",
+		},
+		cli.BoolFlag{
+			Name:  "upgrade",
+			Usage: "upgrade the stacks deployed from the template to every published version",
+		},
+		cli.StringFlag{
+			Name:  "catalog-name",
+			Usage: "name the catalog repo is added to rancher as, required with --upgrade",
+		},
+		cli.StringSliceFlag{
+			Name:  "stackname",
+			Usage: "stack to upgrade, may be repeated, defaults to every stack deployed from the template",
+		},
+		cli.IntFlag{
+			Name:  "parallelism",
+			Usage: "number of stacks upgraded at once",
+			Value: 1,
+		},
+		cli.DurationFlag{
+			Name:  "wait-timeout",
+			Usage: "how long to wait for the catalog to serve the new version",
+			Value: 5 * time.Minute,
+		},
+		cli.DurationFlag{
+			Name:  "upgrade-timeout",
+			Usage: "how long to wait for a stack upgrade to finish",
+			Value: 3 * time.Minute,
+		},
+	)
+	syncFlags = append(syncFlags, catalogRepoFlags()...)
+
+	return cli.Command{
+		Name:   "sync",
+		Usage:  "publish catalog template versions from the commits of a source repo",
+		Action: syncSource,
+		Flags:  syncFlags,
+	}
+}
+
+func syncSource(ctx *cli.Context) error {
+	if ctx.String("source-url") == "" {
+		return errors.New("--source-url is required")
+	}
+	if ctx.String("cacheroot") == "" {
+		return errors.New("--cacheroot is required")
+	}
+	if ctx.String("listen") != "" && ctx.String("secret") == "" {
+		return errors.New("--secret is required to authenticate webhooks")
+	}
+	if ctx.Bool("upgrade") && ctx.String("catalog-name") == "" {
+		return errors.New("--catalog-name is required to upgrade stacks")
+	}
+	catalogConfig, err := catalogRepoConfig(ctx)
+	if err != nil {
+		return err
+	}
+	catalogConfig.TemplateFolderName = ctx.String("foldername")
+	catalogConfig.TemplateIsSystem = ctx.Bool("system")
+	catalogConfig.TemplateBase = ctx.String("template-base")
+	catalogConfig.Layout = service.LayoutRancher
+	catalogConfig.CatalogName = ctx.String("catalog-name")
+	source := &model.SourceSync{
+		GitUrl:    ctx.String("source-url"),
+		GitBranch: ctx.String("source-branch"),
+		Path:      ctx.String("source-path"),
+		CacheRoot: ctx.String("cacheroot"),
+	}
+
+	var api service.RancherAPI
+	stackConfig := &model.StackUpgrade{
+		Parallelism: ctx.Int("parallelism"),
+		WaitTimeout: ctx.Duration("upgrade-timeout"),
+	}
+	if ctx.Bool("upgrade") {
+		conn, err := newConnection(ctx)
+		if err != nil {
+			return err
+		}
+		if _, api, err = conn.singleEnvironment(); err != nil {
+			return err
+		}
+	}
+
+	sync := func() error {
+		//every sync starts from the flags, publishing fills in the template and its files.
+		config := *catalogConfig
+		commit, changed, err := service.PrepareSourceVersion(source, &config)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			logrus.Debugf("template is up to date with source commit %s", commit)
+			return nil
+		}
+		logrus.Infof("publishing source commit %s", commit)
+		if api == nil {
+			_, err := service.PublishTemplateVersion(&config)
+			return err
+		}
+		results, err := service.Release(api, &config, stackConfig, ctx.StringSlice("stackname"), ctx.Duration("wait-timeout"))
+		logStackResults("", results)
+		return err
+	}
+	if ctx.Bool("once") {
+		return sync()
+	}
+
+	//syncs triggered while one runs are folded into a single one.
+	trigger := make(chan struct{}, 1)
+	poke := func() {
+		select {
+		case trigger <- struct{}{}:
+		default:
+		}
+	}
+	poke()
+	var tick <-chan time.Time
+	if ctx.Duration("interval") > 0 {
+		ticker := time.NewTicker(ctx.Duration("interval"))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	var server *http.Server
+	done := make(chan error, 1)
+	if ctx.String("listen") != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/webhook", webhook.NewGitHandler(ctx.String("secret"), source.GitBranch, func(push *webhook.GitPush) {
+			poke()
+		}))
+		mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		})
+		server = &http.Server{Addr: ctx.String("listen"), Handler: mux}
+		go func() {
+			logrus.Infof("receiving push webhooks on %s/webhook", ctx.String("listen"))
+			done <- server.ListenAndServe()
+		}()
+	} else if tick == nil {
+		return errors.New("either --interval or --listen is needed to notice new commits")
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	for {
+		select {
+		case <-trigger:
+			if err := sync(); err != nil {
+				logrus.Errorf("sync of %s failed: %v", source.GitUrl, err)
+			}
+		case <-tick:
+			poke()
+		case err := <-done:
+			return err
+		case <-stop:
+			logrus.Info("shutting down")
+			if server != nil {
+				shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				defer cancel()
+				if err := server.Shutdown(shutdown); err != nil {
+					logrus.Warnf("shutdown: %v", err)
+				}
+			}
+			return nil
+		}
+	}
+}
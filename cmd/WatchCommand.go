@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/rancher-upgrader/model"
+	"github.com/rancher/rancher-upgrader/service"
+	"github.com/urfave/cli"
+)
+
+const defaultOptInLabel = "io.rancher.upgrader.auto_upgrade=true"
+
+func WatchCommand() cli.Command {
+	watchFlags := append(connectionFlags(),
+		cli.StringSliceFlag{
+			Name:  "stackname",
+			Usage: "stack to follow the catalog, may be repeated, defaults to every opted in stack",
+		},
+		cli.StringFlag{
+			Name:  "template",
+			Usage: "only follow stacks deployed from this catalog template, e.g. library:infra*ipsec",
+		},
+		cli.StringFlag{
+			Name:  "opt-in-label",
+			Usage: "label key=value one of the services of a stack must carry, empty to follow the named stacks without it",
+			Value: defaultOptInLabel,
+		},
+		cli.StringSliceFlag{
+			Name:  "window",
+			Usage: "maintenance window in cron syntax upgrades are allowed in, e.g. '* 0-5,22-23 * * *', may be repeated, any time without one",
+		},
+		cli.StringFlag{
+			Name:  "timezone",
+			Usage: "timezone of the windows, e.g. Europe/Berlin, defaults to the local one",
+		},
+		cli.IntFlag{
+			Name:  "max-upgrades",
+			Usage: "maximum number of stacks upgraded per window, 0 for no limit",
+		},
+		cli.DurationFlag{
+			Name:  "interval",
+			Usage: "how often the catalog is checked for new versions",
+			Value: 5 * time.Minute,
+		},
+		cli.DurationFlag{
+			Name:  "wait-timeout",
+			Usage: "how long to wait for a stack upgrade to settle",
+			Value: 3 * time.Minute,
+		},
+		cli.BoolFlag{
+			Name:  "once",
+			Usage: "check once and exit",
+		},
+	)
+
+	return cli.Command{
+		Name:   "watch",
+		Usage:  "upgrade stacks to new catalog versions as they appear, within maintenance windows",
+		Action: watch,
+		Flags:  watchFlags,
+	}
+}
+
+func watch(ctx *cli.Context) error {
+	optInLabel := ctx.String("opt-in-label")
+	if optInLabel == "" && len(ctx.StringSlice("stackname")) == 0 && ctx.String("template") == "" {
+		return errors.New("without --opt-in-label the stacks must be selected with --stackname or --template")
+	}
+	if ctx.Duration("interval") <= 0 {
+		return errors.New("--interval must be positive")
+	}
+	location := time.Local
+	if ctx.String("timezone") != "" {
+		var err error
+		if location, err = time.LoadLocation(ctx.String("timezone")); err != nil {
+			return err
+		}
+	}
+	conn, err := newConnection(ctx)
+	if err != nil {
+		return err
+	}
+	envs, err := conn.resolve()
+	if err != nil {
+		return err
+	}
+
+	watchers := make([]*service.Watcher, len(envs))
+	for i, env := range envs {
+		api, err := conn.client(env)
+		if err != nil {
+			return err
+		}
+		watchers[i], err = service.NewWatcher(api, &model.AutoUpgrade{
+			StackNames:  ctx.StringSlice("stackname"),
+			Template:    ctx.String("template"),
+			OptInLabel:  optInLabel,
+			Windows:     ctx.StringSlice("window"),
+			Location:    location,
+			MaxUpgrades: ctx.Int("max-upgrades"),
+			WaitTimeout: ctx.Duration("wait-timeout"),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	check := func() error {
+		var lastErr error
+		for i, w := range watchers {
+			results, err := w.Check(time.Now())
+			logStackResults(envs[i].Name, results)
+			if err != nil {
+				logrus.Errorf("environment %s: %v", envs[i].Name, err)
+				lastErr = err
+			}
+		}
+		return lastErr
+	}
+	if ctx.Bool("once") {
+		return check()
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	ticker := time.NewTicker(ctx.Duration("interval"))
+	defer ticker.Stop()
+	for {
+		check()
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return nil
+		}
+	}
+}
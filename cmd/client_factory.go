@@ -2,34 +2,302 @@ package cmd
 
 import (
 	"fmt"
+	"path/filepath"
 	"time"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/mitchellh/go-homedir"
 
-	"github.com/rancher/go-rancher/v2"
+	"github.com/rancher/rancher-upgrader/profile"
+	"github.com/rancher/rancher-upgrader/service"
+	"github.com/rancher/rancher-upgrader/transport"
 	"github.com/urfave/cli"
 )
 
-type RancherClientFactory interface {
-	GetClient(projectID string) (*client.RancherClient, error)
+//connectionFlags select the environments a command connects to, either by their endpoint URLs
+//or by their names on a server, and the keys used. A profile of the config file fills in
+//whatever is not given on the command line.
+func connectionFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:   "profile",
+			Usage:  "connection profile of the config file, defaults to the current profile",
+			EnvVar: "RANCHER_UPGRADER_PROFILE",
+		},
+		cli.StringSliceFlag{
+			Name:   "envurl",
+			Usage:  "Environment ENDPOINT URL, may be repeated",
+			EnvVar: "CATTLE_URL",
+		},
+		cli.StringFlag{
+			Name:   "url",
+			Usage:  "rancher server URL, environments are picked with --environment",
+			EnvVar: "RANCHER_URL",
+		},
+		cli.StringSliceFlag{
+			Name:   "environment, project",
+			Usage:  "environment name or ID on --url, may be repeated",
+			EnvVar: "RANCHER_ENVIRONMENT",
+		},
+		cli.StringFlag{
+			Name:   "accesskey",
+			Usage:  "Environment ACCESS KEY",
+			EnvVar: "CATTLE_ACCESS_KEY",
+		},
+		cli.StringFlag{
+			Name:   "secretkey",
+			Usage:  "Environment SECRET KEY",
+			EnvVar: "CATTLE_SECRET_KEY",
+		},
+		cli.DurationFlag{
+			Name:  "timeout",
+			Usage: "timeout of rancher API requests",
+			Value: 30 * time.Second,
+		},
+		cli.StringFlag{
+			Name:   "ca-cert",
+			Usage:  "CA certificate (PEM) the rancher server certificate is signed with",
+			EnvVar: "RANCHER_CA_CERT",
+		},
+		cli.StringFlag{
+			Name:   "client-cert",
+			Usage:  "client certificate (PEM) to authenticate with",
+			EnvVar: "RANCHER_CLIENT_CERT",
+		},
+		cli.StringFlag{
+			Name:   "client-key",
+			Usage:  "key (PEM) of the client certificate",
+			EnvVar: "RANCHER_CLIENT_KEY",
+		},
+		cli.BoolFlag{
+			Name:  "insecure-skip-verify",
+			Usage: "don't verify the TLS certificate of the rancher server",
+		},
+		cli.StringFlag{
+			Name:  "proxy",
+			Usage: "HTTP(S) proxy URL, HTTP_PROXY, HTTPS_PROXY and NO_PROXY apply without it",
+		},
+	}
+}
+
+//environmentFlags are the connectionFlags of commands running in several environments at once.
+func environmentFlags() []cli.Flag {
+	return append(connectionFlags(),
+		cli.StringFlag{
+			Name:  "order",
+			Usage: "run in several environments sequential, in the given order, or parallel",
+			Value: "sequential",
+		},
+		cli.BoolFlag{
+			Name:  "continue-on-error",
+			Usage: "keep going with the next environments when one fails",
+		},
+		cli.DurationFlag{
+			Name:  "wait-timeout",
+			Usage: "how long to wait for an upgrade to finish",
+			Value: 3 * time.Minute,
+		},
+	)
+}
+
+//connection is what the connectionFlags and the selected profile resolve to.
+type connection struct {
+	envUrls      []string
+	url          string
+	environments []string
+	accessKey    string
+	secretKey    string
+	timeout      time.Duration
+	waitTimeout  time.Duration
+	transport    transport.Options
+}
+
+//newConnection reads the connectionFlags and sets up TLS and proxying for the HTTP calls to
+//the rancher servers. A profile named with --profile fills in every setting not given on the
+//command line, the current profile only does when neither --envurl nor --url is given at all,
+//so CATTLE_URL and friends keep working as before.
+func newConnection(ctx *cli.Context) (*connection, error) {
+	c := &connection{
+		envUrls:      ctx.StringSlice("envurl"),
+		url:          ctx.String("url"),
+		environments: ctx.StringSlice("environment"),
+		accessKey:    ctx.String("accesskey"),
+		secretKey:    ctx.String("secretkey"),
+		timeout:      ctx.Duration("timeout"),
+		waitTimeout:  ctx.Duration("wait-timeout"),
+		transport: transport.Options{
+			CACert:             ctx.String("ca-cert"),
+			ClientCert:         ctx.String("client-cert"),
+			ClientKey:          ctx.String("client-key"),
+			InsecureSkipVerify: ctx.Bool("insecure-skip-verify"),
+			Proxy:              ctx.String("proxy"),
+		},
+	}
+	if err := c.applyProfile(ctx); err != nil {
+		return nil, err
+	}
+	if err := transport.Install(&c.transport, append(c.envUrls, c.url)...); err != nil {
+		return nil, err
+	}
+	return c, nil
 }
 
-type ClientFactory struct{}
+func (c *connection) applyProfile(ctx *cli.Context) error {
+	name := ctx.String("profile")
+	if name == "" && (len(c.envUrls) > 0 || c.url != "") {
+		return nil
+	}
+	path, err := profile.DefaultPath()
+	if err != nil {
+		if name == "" {
+			return nil
+		}
+		return err
+	}
+	config, err := profile.Load(path)
+	if err != nil {
+		return err
+	}
+	p, err := config.Get(name)
+	if err != nil || p == nil {
+		return err
+	}
+
+	if !ctx.IsSet("envurl") && !ctx.IsSet("url") {
+		c.envUrls, c.url = nil, p.Url
+		if p.EnvUrl != "" {
+			c.envUrls = []string{p.EnvUrl}
+		}
+	}
+	if !ctx.IsSet("environment") && !ctx.IsSet("project") && p.Environment != "" {
+		c.environments = []string{p.Environment}
+	}
+	if !ctx.IsSet("accesskey") && p.AccessKey != "" {
+		c.accessKey = p.AccessKey
+	}
+	if !ctx.IsSet("secretkey") && p.SecretKey != "" {
+		c.secretKey = p.SecretKey
+	}
+	if !ctx.IsSet("timeout") && p.Timeout > 0 {
+		c.timeout = p.Timeout
+	}
+	if !ctx.IsSet("wait-timeout") && p.WaitTimeout > 0 {
+		c.waitTimeout = p.WaitTimeout
+	}
+	if !ctx.IsSet("ca-cert") && p.CACert != "" {
+		c.transport.CACert = p.CACert
+	}
+	if !ctx.IsSet("client-cert") && !ctx.IsSet("client-key") && p.ClientCert != "" {
+		c.transport.ClientCert, c.transport.ClientKey = p.ClientCert, p.ClientKey
+	}
+	if !ctx.IsSet("insecure-skip-verify") && p.InsecureSkipVerify {
+		c.transport.InsecureSkipVerify = true
+	}
+	if !ctx.IsSet("proxy") && p.Proxy != "" {
+		c.transport.Proxy = p.Proxy
+	}
+	return nil
+}
+
+//resolve lists the environments selected.
+func (c *connection) resolve() ([]service.EnvTarget, error) {
+	var envs []service.EnvTarget
+	for _, envurl := range c.envUrls {
+		envs = append(envs, service.EnvTarget{Name: envurl, Url: envurl})
+	}
+	if c.url != "" {
+		if len(c.environments) == 0 {
+			return nil, fmt.Errorf("--environment is required with --url")
+		}
+		resolved, err := service.ResolveEnvironments(c.url, c.accessKey, c.secretKey, c.environments, environmentCache())
+		if err != nil {
+			return nil, err
+		}
+		envs = append(envs, resolved...)
+	}
+	if len(envs) == 0 {
+		return nil, fmt.Errorf("no environment given, use --envurl, --url with --environment or --profile")
+	}
+	return envs, nil
+}
+
+//client connects to the environment.
+func (c *connection) client(env service.EnvTarget) (service.RancherAPI, error) {
+	transport.AddHost(env.Url)
+	api, err := service.NewRancherAPI(env.Url, c.accessKey, c.secretKey, c.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("Error in creating API client: %v", err)
+	}
+	return api, nil
+}
+
+//singleEnvironment is the one environment commands not running in several environments use.
+func (c *connection) singleEnvironment() (service.EnvTarget, service.RancherAPI, error) {
+	envs, err := c.resolve()
+	if err != nil {
+		return service.EnvTarget{}, nil, err
+	}
+	if len(envs) > 1 {
+		return service.EnvTarget{}, nil, fmt.Errorf("only one environment can be given, got %d", len(envs))
+	}
+	api, err := c.client(envs[0])
+	return envs[0], api, err
+}
+
+//runInEnvironments runs f with a client for every selected environment, logging the result of
+//each one when there are several.
+func runInEnvironments(ctx *cli.Context, conn *connection, f func(env service.EnvTarget, api service.RancherAPI) error) error {
+	envs, err := conn.resolve()
+	if err != nil {
+		return err
+	}
+	order := ctx.String("order")
+	if order != "sequential" && order != "parallel" {
+		return fmt.Errorf("unknown order '%s', use sequential or parallel", order)
+	}
+	run := func(env service.EnvTarget) error {
+		api, err := conn.client(env)
+		if err != nil {
+			return err
+		}
+		return f(env, api)
+	}
+	if len(envs) == 1 {
+		return run(envs[0])
+	}
+
+	results, err := service.RunEnvironments(envs, order == "parallel", ctx.Bool("continue-on-error"), run)
+	for _, r := range results {
+		if r.Err == nil {
+			logrus.Infof("environment %s: ok", r.Env)
+		} else {
+			logrus.Errorf("environment %s: %v", r.Env, r.Err)
+		}
+	}
+	return err
+}
+
+//logStackResults logs the outcome of every stack of a batch, env prefixes the lines when given.
+func logStackResults(env string, results []service.StackResult) {
+	prefix := ""
+	if env != "" {
+		prefix = "environment " + env + ": "
+	}
+	for _, r := range results {
+		if r.Err == nil {
+			logrus.Infof("%sstack '%s': ok", prefix, r.Stack)
+		} else {
+			logrus.Errorf("%sstack '%s': %v", prefix, r.Stack, r.Err)
+		}
+	}
+}
 
-func (f *ClientFactory) GetClient(ctx *cli.Context) (*client.RancherClient, error) {
-	envEndpoint := ctx.String("envurl")
-	//projectID := ctx.String("env")
-	//url := fmt.Sprintf("%s/projects/%s/schemas", cattleURL, projectID)
-	url := envEndpoint + "/schemas"
-	apiClient, err := client.NewRancherClient(&client.ClientOpts{
-		Timeout:   time.Second * 30,
-		Url:       url,
-		AccessKey: ctx.String("accesskey"),
-		SecretKey: ctx.String("secretkey"),
-	})
+//environmentCache keeps the environments resolved by name in the user's home, if there is one.
+func environmentCache() *service.EnvironmentCache {
+	home, err := homedir.Dir()
 	if err != nil {
-		logrus.Fatal(err)
-		return &client.RancherClient{}, fmt.Errorf("Error in creating API client")
+		logrus.Debugf("not caching environments: %v", err)
+		return nil
 	}
-	return apiClient, nil
+	return &service.EnvironmentCache{File: filepath.Join(home, ".rancher-upgrader", "cache", "environments.json")}
 }
@@ -0,0 +1,173 @@
+package forge
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//PullRequest describes the change to propose.
+type PullRequest struct {
+	Repo  string //owner/name of the repository, as returned by RepoPath
+	Head  string //branch holding the change
+	Base  string //branch to merge into
+	Title string
+	Body  string
+}
+
+//Forge opens pull (merge) requests on a git hosting service.
+type Forge interface {
+	//CreatePullRequest opens the pull request and returns its web URL.
+	CreatePullRequest(pr *PullRequest) (string, error)
+}
+
+//New returns the Forge for kind (github, gitlab or gitea). apiURL may be empty for the public
+//GitHub and GitLab services.
+func New(kind, apiURL, token string) (Forge, error) {
+	c := &restClient{
+		apiURL: strings.TrimRight(apiURL, "/"),
+		token:  token,
+		http:   &http.Client{Timeout: 30 * time.Second},
+	}
+	switch kind {
+	case "github":
+		if c.apiURL == "" {
+			c.apiURL = "https://api.github.com"
+		}
+		return &GitHub{c}, nil
+	case "gitlab":
+		if c.apiURL == "" {
+			c.apiURL = "https://gitlab.com"
+		}
+		return &GitLab{c}, nil
+	case "gitea":
+		if c.apiURL == "" {
+			return nil, errors.New("gitea needs the server URL")
+		}
+		return &Gitea{c}, nil
+	default:
+		return nil, fmt.Errorf("unsupported forge '%s', needs one of github, gitlab, gitea", kind)
+	}
+}
+
+//RepoPath extracts owner/name from a git URL such as https://host/owner/name.git,
+//git@host:owner/name.git or ssh://git@host/owner/name.git.
+func RepoPath(gitURL string) (string, error) {
+	var p string
+	if strings.Contains(gitURL, "://") {
+		u, err := url.Parse(gitURL)
+		if err != nil {
+			return "", err
+		}
+		p = u.Path
+	} else if i := strings.Index(gitURL, ":"); i >= 0 {
+		p = gitURL[i+1:]
+	}
+	p = strings.TrimSuffix(strings.Trim(p, "/"), ".git")
+	if !strings.Contains(p, "/") {
+		return "", fmt.Errorf("cannot find the repository owner and name in '%s'", gitURL)
+	}
+	return p, nil
+}
+
+//GitHub opens pull requests through the GitHub REST API.
+type GitHub struct {
+	*restClient
+}
+
+//CreatePullRequest implements Forge.
+func (g *GitHub) CreatePullRequest(pr *PullRequest) (string, error) {
+	resp := struct {
+		HTMLURL string `json:"html_url"`
+	}{}
+	err := g.post(fmt.Sprintf("%s/repos/%s/pulls", g.apiURL, pr.Repo), "token "+g.token, map[string]string{
+		"title": pr.Title,
+		"head":  pr.Head,
+		"base":  pr.Base,
+		"body":  pr.Body,
+	}, &resp)
+	return resp.HTMLURL, err
+}
+
+//GitLab opens merge requests through the GitLab v4 REST API.
+type GitLab struct {
+	*restClient
+}
+
+//CreatePullRequest implements Forge.
+func (g *GitLab) CreatePullRequest(pr *PullRequest) (string, error) {
+	resp := struct {
+		WebURL string `json:"web_url"`
+	}{}
+	err := g.post(fmt.Sprintf("%s/api/v4/projects/%s/merge_requests", g.apiURL, url.QueryEscape(pr.Repo)), "Bearer "+g.token, map[string]string{
+		"title":         pr.Title,
+		"source_branch": pr.Head,
+		"target_branch": pr.Base,
+		"description":   pr.Body,
+	}, &resp)
+	return resp.WebURL, err
+}
+
+//Gitea opens pull requests through the Gitea v1 REST API.
+type Gitea struct {
+	*restClient
+}
+
+//CreatePullRequest implements Forge.
+func (g *Gitea) CreatePullRequest(pr *PullRequest) (string, error) {
+	resp := struct {
+		HTMLURL string `json:"html_url"`
+	}{}
+	err := g.post(fmt.Sprintf("%s/api/v1/repos/%s/pulls", g.apiURL, pr.Repo), "token "+g.token, map[string]string{
+		"title": pr.Title,
+		"head":  pr.Head,
+		"base":  pr.Base,
+		"body":  pr.Body,
+	}, &resp)
+	return resp.HTMLURL, err
+}
+
+type restClient struct {
+	apiURL string
+	token  string
+	http   *http.Client
+}
+
+func (c *restClient) post(requestURL, authorization string, body interface{}, result interface{}) error {
+	content, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", requestURL, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "create pull request failed")
+	}
+	defer resp.Body.Close()
+
+	respContent, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("create pull request failed: %s: %s", resp.Status, respContent)
+	}
+	if err := json.Unmarshal(respContent, result); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("create pull request failed, Failed to parse: %s", respContent))
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package forge
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCreatePullRequest(t *testing.T) {
+	var gotPath, gotAuth string
+	var gotBody map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.EscapedPath()
+		gotAuth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&gotBody)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"html_url": "http://forge/pr/1", "web_url": "http://forge/mr/1"}`))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		kind, path, auth, url, headKey string
+	}{
+		{"github", "/repos/rancher/catalog/pulls", "token t0k", "http://forge/pr/1", "head"},
+		{"gitlab", "/api/v4/projects/rancher%2Fcatalog/merge_requests", "Bearer t0k", "http://forge/mr/1", "source_branch"},
+		{"gitea", "/api/v1/repos/rancher/catalog/pulls", "token t0k", "http://forge/pr/1", "head"},
+	}
+	for _, test := range tests {
+		f, err := New(test.kind, server.URL, "t0k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		url, err := f.CreatePullRequest(&PullRequest{Repo: "rancher/catalog", Head: "feature", Base: "master", Title: "t"})
+		if err != nil {
+			t.Fatalf("%s: %v", test.kind, err)
+		}
+		if url != test.url || gotPath != test.path || gotAuth != test.auth || gotBody[test.headKey] != "feature" {
+			t.Errorf("%s: unexpected request %s %s %v, url %s", test.kind, gotPath, gotAuth, gotBody, url)
+		}
+	}
+}
+
+func TestRepoPath(t *testing.T) {
+	for in, expected := range map[string]string{
+		"https://github.com/rancher/catalog.git":   "rancher/catalog",
+		"git@github.com:rancher/catalog.git":       "rancher/catalog",
+		"ssh://git@gitlab.com/group/sub/catalog":   "group/sub/catalog",
+		"https://user@gitea.local/rancher/catalog": "rancher/catalog",
+	} {
+		if p, err := RepoPath(in); err != nil || p != expected {
+			t.Errorf("%s: expected %s, got %s (%v)", in, expected, p, err)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+//askpass answers git's username and password prompts from the environment, so credentials
+//never show up in a URL or a process listing.
+const askpass = `#!/bin/sh
+case "$1" in
+Username*) echo "$RANCHER_UPGRADER_GIT_USERNAME" ;;
+*) echo "$RANCHER_UPGRADER_GIT_PASSWORD" ;;
+esac
+`
+
+//SplitURLCredentials removes user and password embedded in an https URL and returns them.
+func SplitURLCredentials(rawurl string) (string, string, string) {
+	u, err := url.Parse(rawurl)
+	if err != nil || u.User == nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return rawurl, "", ""
+	}
+	password, _ := u.User.Password()
+	user := u.User.Username()
+	u.User = nil
+	return u.String(), user, password
+}
+
+//FillFromHelper asks the git credential helper for the credentials of rawurl, the way git
+//itself would: a helper name runs git-credential-<name>, a "!" prefix runs a shell command.
+func (a *Auth) FillFromHelper(rawurl string) error {
+	if a.CredentialHelper == "" || a.Password != "" || a.SSHKeyFile != "" {
+		return nil
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+
+	var cmd *exec.Cmd
+	switch {
+	case strings.HasPrefix(a.CredentialHelper, "!"):
+		cmd = exec.Command("/bin/sh", "-c", a.CredentialHelper[1:]+" get")
+	case strings.HasPrefix(a.CredentialHelper, "/"):
+		cmd = exec.Command("/bin/sh", "-c", a.CredentialHelper+" get")
+	default:
+		cmd = exec.Command("/bin/sh", "-c", "git-credential-"+a.CredentialHelper+" get")
+	}
+	input := fmt.Sprintf("protocol=%s\nhost=%s\npath=%s\n", u.Scheme, u.Host, strings.TrimPrefix(u.Path, "/"))
+	if a.Username != "" {
+		input += "username=" + a.Username + "\n"
+	}
+	cmd.Stdin = strings.NewReader(input + "\n")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("credential helper %s: %v: %s", a.CredentialHelper, err, strings.TrimSpace(stderr.String()))
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "username":
+			a.Username = parts[1]
+		case "password":
+			a.Password = parts[1]
+		}
+	}
+	if a.Password == "" {
+		return fmt.Errorf("credential helper %s returned no password for %s", a.CredentialHelper, u.Host)
+	}
+	return nil
+}
+
+//env returns the environment passing the credentials to the git binary, and a cleanup
+//function removing the temporary askpass script.
+func (a *Auth) env() ([]string, func(), error) {
+	env := append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	cleanup := func() {}
+
+	if a.SSHKeyFile != "" {
+		ssh := "ssh -i " + shellQuote(a.SSHKeyFile) + " -o IdentitiesOnly=yes"
+		if a.KnownHostsFile != "" {
+			ssh += " -o " + shellQuote("UserKnownHostsFile="+a.KnownHostsFile) + " -o StrictHostKeyChecking=yes"
+		}
+		env = append(env, "GIT_SSH_COMMAND="+ssh)
+	}
+
+	if a.Password != "" {
+		f, err := ioutil.TempFile("", "rancher-upgrader-askpass")
+		if err != nil {
+			return nil, cleanup, errors.Wrap(err, "create askpass failed")
+		}
+		cleanup = func() { os.Remove(f.Name()) }
+		_, err = f.WriteString(askpass)
+		if err == nil {
+			err = f.Chmod(0700)
+		}
+		f.Close()
+		if err != nil {
+			cleanup()
+			return nil, func() {}, errors.Wrap(err, "create askpass failed")
+		}
+		username := a.Username
+		if username == "" {
+			username = "git"
+		}
+		env = append(env,
+			"GIT_ASKPASS="+f.Name(),
+			"RANCHER_UPGRADER_GIT_USERNAME="+username,
+			"RANCHER_UPGRADER_GIT_PASSWORD="+a.Password,
+		)
+	}
+	return env, cleanup, nil
+}
+
+//shellQuote quotes s as a single word for the shell git runs GIT_SSH_COMMAND with.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
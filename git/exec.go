@@ -0,0 +1,103 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+//Exec runs the git binary.
+type Exec struct {
+	Auth   Auth
+	Author Author
+}
+
+func (e *Exec) Clone(path, url, branch string) error {
+	log.Debugf("cloning branch %s into %s", branch, path)
+	return e.run("clone", "-b", branch, "--single-branch", url, path)
+}
+
+func (e *Exec) Update(path, branch string) error {
+	if err := e.run("-C", path, "fetch", "origin", branch); err != nil {
+		return err
+	}
+	if err := e.run("-C", path, "checkout", "-f", "-B", branch, fmt.Sprintf("origin/%s", branch)); err != nil {
+		return err
+	}
+	return e.run("-C", path, "clean", "-fdx")
+}
+
+func (e *Exec) CheckoutBranch(path, branch string) error {
+	return e.run("-C", path, "checkout", "-B", branch)
+}
+
+func (e *Exec) Commit(path, message string) error {
+	if err := e.run("-C", path, "add", "-A", "."); err != nil {
+		return err
+	}
+	author := e.Author
+	if author.Name == "" {
+		author = defaultAuthor
+	}
+	return e.run("-C", path, "-c", "user.name="+author.Name, "-c", "user.email="+author.Email, "commit", "-m", message)
+}
+
+func (e *Exec) Push(path, repo, branch string) error {
+	return e.run("-C", path, "push", repo, "HEAD:refs/heads/"+branch)
+}
+
+func (e *Exec) HeadCommit(path string) (string, error) {
+	cmd := exec.Command("git", "-C", path, "rev-parse", "HEAD")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", cmdError(cmd.Args, err, &stderr)
+	}
+	return strings.Trim(string(output), "\n"), nil
+}
+
+func (e *Exec) IsValid(url string) bool {
+	err := e.run("ls-remote", url)
+	return (err == nil)
+}
+
+func (e *Exec) run(arg ...string) error {
+	env, cleanup, err := e.Auth.env()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	cmd := exec.Command("git", arg...)
+	cmd.Env = env
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if log.GetLevel() >= log.DebugLevel {
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+	}
+	if err := cmd.Run(); err != nil {
+		return cmdError(cmd.Args, err, &stderr)
+	}
+	return nil
+}
+
+//cmdError names the failed git subcommand and carries what git printed on stderr.
+func cmdError(args []string, err error, stderr *bytes.Buffer) error {
+	sub := ""
+	for i := 1; i < len(args); i++ {
+		if args[i] == "-C" || args[i] == "-c" {
+			i++
+			continue
+		}
+		sub = args[i]
+		break
+	}
+	return fmt.Errorf("git %s: %v: %s", sub, err, strings.TrimSpace(stderr.String()))
+}
@@ -2,57 +2,87 @@ package git
 
 import (
 	"fmt"
-	"os"
-	"os/exec"
-	"strings"
-
-	log "github.com/Sirupsen/logrus"
 )
 
-func Clone(path, url, branch string) error {
-	log.Infof("args:%v,%v,%v", branch, url, path)
-	return runcmd("git", "clone", "-b", branch, "--single-branch", url, path)
+//Auth holds the credentials used to talk to the remote repository. Password may also be an
+//access token, SSHKeyFile selects public key authentication.
+type Auth struct {
+	Username         string
+	Password         string
+	SSHKeyFile       string
+	KnownHostsFile   string
+	CredentialHelper string
 }
 
-func Update(path, branch string) error {
-	if err := runcmd("git", "-C", path, "fetch"); err != nil {
-		return err
-	}
-	return runcmd("git", "-C", path, "checkout", fmt.Sprintf("origin/%s", branch))
+//Author is the identity new commits are made with.
+type Author struct {
+	Name  string
+	Email string
+}
+
+//Git is a git implementation working on a local clone at path.
+type Git interface {
+	Clone(path, url, branch string) error
+	//Update fetches branch and hard resets the working tree to it, dropping any local change.
+	Update(path, branch string) error
+	//CheckoutBranch creates branch at the current commit and switches to it, keeping changes.
+	CheckoutBranch(path, branch string) error
+	//Commit adds every change under path and commits it with message.
+	Commit(path, message string) error
+	//Push pushes HEAD to branch of repo. It never forces, a rejected push is returned as error.
+	Push(path, repo, branch string) error
+	HeadCommit(path string) (string, error)
+	IsValid(url string) bool
 }
 
-//LazyPush add,commit and push changes.
-func LazyPush(path, repo, refspec string) error {
+var defaultAuthor = Author{Name: "rancher-upgrader", Email: "rancher-upgrader@localhost"}
 
-	if err := runcmd("git", "-C", path, "add", "."); err != nil {
-		return err
+//Default is the implementation used by the package level functions.
+var Default Git = &Native{}
+
+//New returns the implementation kind, "native" for the pure Go one or "exec" for the one
+//running the git binary.
+func New(kind string, auth Auth, author Author) (Git, error) {
+	if author.Name == "" {
+		author.Name = defaultAuthor.Name
 	}
-	if err := runcmd("git", "-C", path, "commit", "-m", "update"); err != nil {
-		return err
+	if author.Email == "" {
+		author.Email = defaultAuthor.Email
 	}
-	//-f is added for test purpose, remove later.
-	if err := runcmd("git", "-C", path, "push", repo, refspec, "-f"); err != nil {
-		return err
+	switch kind {
+	case "", "native":
+		return &Native{Auth: auth, Author: author}, nil
+	case "exec":
+		return &Exec{Auth: auth, Author: author}, nil
+	default:
+		return nil, fmt.Errorf("unsupported git implementation '%s', needs native or exec", kind)
 	}
-	return nil
 }
 
-func HeadCommit(path string) (string, error) {
-	cmd := exec.Command("git", "-C", path, "rev-parse", "HEAD")
-	output, err := cmd.Output()
-	return strings.Trim(string(output), "\n"), err
+func Clone(path, url, branch string) error {
+	return Default.Clone(path, url, branch)
 }
 
-func IsValid(url string) bool {
-	err := runcmd("git", "ls-remote", url)
-	return (err == nil)
+func Update(path, branch string) error {
+	return Default.Update(path, branch)
 }
 
-func runcmd(name string, arg ...string) error {
-	cmd := exec.Command(name, arg...)
-	if log.GetLevel() >= log.DebugLevel {
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-	}
-	return cmd.Run()
+func CheckoutBranch(path, branch string) error {
+	return Default.CheckoutBranch(path, branch)
+}
+
+func Commit(path, message string) error {
+	return Default.Commit(path, message)
+}
+
+func Push(path, repo, branch string) error {
+	return Default.Push(path, repo, branch)
+}
+
+func HeadCommit(path string) (string, error) {
+	return Default.HeadCommit(path)
+}
+
+func IsValid(url string) bool {
+	return Default.IsValid(url)
 }
@@ -0,0 +1,148 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//gitRun runs the git binary in dir for the test setup.
+func gitRun(t *testing.T, dir string, arg ...string) string {
+	args := append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@localhost"}, arg...)
+	output, err := exec.Command("git", args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v: %s", strings.Join(arg, " "), err, output)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+//upstreamCommit commits content to file on master of the bare repo remote, as another user
+//would, and returns the new commit.
+func upstreamCommit(t *testing.T, remote, file, content string) string {
+	work, err := ioutil.TempDir("", "upstream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(work)
+	gitRun(t, work, "clone", "-q", remote, ".")
+	if err := ioutil.WriteFile(filepath.Join(work, file), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitRun(t, work, "add", "-A")
+	gitRun(t, work, "commit", "-q", "-m", "change "+file)
+	gitRun(t, work, "push", "-q", "origin", "HEAD:refs/heads/master")
+	return gitRun(t, work, "rev-parse", "HEAD")
+}
+
+func TestImplementations(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary is needed to set up the repos")
+	}
+	for _, kind := range []string{"native", "exec"} {
+		t.Run(kind, func(t *testing.T) {
+			testImplementation(t, kind)
+		})
+	}
+}
+
+func testImplementation(t *testing.T, kind string) {
+	dir, err := ioutil.TempDir("", "git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	remote := filepath.Join(dir, "remote.git")
+	clone := filepath.Join(dir, "clone")
+	gitRun(t, dir, "init", "-q", "--bare", remote)
+	gitRun(t, remote, "symbolic-ref", "HEAD", "refs/heads/master")
+	first := upstreamCommit(t, remote, "README.md", "first\n")
+
+	g, err := New(kind, Auth{}, Author{Name: "Jane Doe", Email: "jane@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Clone(clone, remote, "master"); err != nil {
+		t.Fatal(err)
+	}
+	if head, err := g.HeadCommit(clone); err != nil || head != first {
+		t.Fatalf("expected head %s after clone, got %s %v", first, head, err)
+	}
+
+	//local changes and untracked leftovers are dropped by Update.
+	second := upstreamCommit(t, remote, "README.md", "second\n")
+	if err := ioutil.WriteFile(filepath.Join(clone, "README.md"), []byte("local\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(clone, "leftover"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(clone, "leftover", "file"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Update(clone, "master"); err != nil {
+		t.Fatal(err)
+	}
+	if head, err := g.HeadCommit(clone); err != nil || head != second {
+		t.Fatalf("expected head %s after update, got %s %v", second, head, err)
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(clone, "README.md")); err != nil || string(content) != "second\n" {
+		t.Errorf("expected README of the remote after update, got %q %v", content, err)
+	}
+	if _, err := os.Stat(filepath.Join(clone, "leftover", "file")); !os.IsNotExist(err) {
+		t.Errorf("expected untracked leftover to be removed by update, got %v", err)
+	}
+
+	if err := g.CheckoutBranch(clone, "feature"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(clone, "new.txt"), []byte("new\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Commit(clone, "add new file"); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Push(clone, remote, "feature"); err != nil {
+		t.Fatal(err)
+	}
+	if commit := gitRun(t, remote, "log", "-1", "--format=%an <%ae> %s", "feature"); commit != "Jane Doe <jane@example.com> add new file" {
+		t.Errorf("unexpected commit pushed to feature: %s", commit)
+	}
+	if parent := gitRun(t, remote, "rev-parse", "feature^"); parent != second {
+		t.Errorf("expected feature to branch off %s, got %s", second, parent)
+	}
+
+	//master moved on meanwhile, pushing the feature commit to it must not overwrite that.
+	third := upstreamCommit(t, remote, "README.md", "third\n")
+	err = g.Push(clone, remote, "master")
+	if err == nil {
+		t.Error("expected push of a diverged branch to be rejected")
+	} else if kind == "exec" && !strings.Contains(err.Error(), "rejected") {
+		t.Errorf("expected push error with git's output, got %v", err)
+	}
+	if head := gitRun(t, remote, "rev-parse", "master"); head != third {
+		t.Errorf("expected master to stay at %s, got %s", third, head)
+	}
+}
+
+func TestExecErrorHasStderr(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary is needed")
+	}
+	dir, err := ioutil.TempDir("", "git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	e := &Exec{}
+	err = e.Clone(filepath.Join(dir, "clone"), filepath.Join(dir, "missing.git"), "master")
+	if err == nil || !strings.HasPrefix(err.Error(), "git clone: ") || !strings.Contains(err.Error(), "missing.git") {
+		t.Errorf("expected clone error with git's output, got %v", err)
+	}
+	if _, err := e.HeadCommit(dir); err == nil || !strings.Contains(err.Error(), "not a git repository") {
+		t.Errorf("expected rev-parse error with git's output, got %v", err)
+	}
+}
@@ -0,0 +1,208 @@
+package git
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+	gogit "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
+)
+
+//Native is a pure Go implementation, it needs no git binary.
+type Native struct {
+	Auth   Auth
+	Author Author
+}
+
+func (n *Native) Clone(path, url, branch string) error {
+	log.Debugf("cloning branch %s into %s", branch, path)
+	auth, err := n.auth()
+	if err != nil {
+		return err
+	}
+	_, err = gogit.PlainClone(path, false, &gogit.CloneOptions{
+		URL:           url,
+		Auth:          auth,
+		ReferenceName: plumbing.NewBranchReferenceName(branch),
+		SingleBranch:  true,
+	})
+	return errors.Wrap(err, "git clone")
+}
+
+func (n *Native) Update(path, branch string) error {
+	r, w, err := open(path)
+	if err != nil {
+		return err
+	}
+	auth, err := n.auth()
+	if err != nil {
+		return err
+	}
+	remoteRef := plumbing.NewRemoteReferenceName("origin", branch)
+	err = r.Fetch(&gogit.FetchOptions{
+		RemoteName: "origin",
+		Auth:       auth,
+		RefSpecs:   []config.RefSpec{config.RefSpec(fmt.Sprintf("+%s:%s", plumbing.NewBranchReferenceName(branch), remoteRef))},
+		Force:      true,
+	})
+	if err != nil && err != gogit.NoErrAlreadyUpToDate {
+		return errors.Wrap(err, "git fetch")
+	}
+
+	ref, err := r.Reference(remoteRef, true)
+	if err != nil {
+		return errors.Wrap(err, "git fetch")
+	}
+	localRef := plumbing.NewBranchReferenceName(branch)
+	if err := r.Storer.SetReference(plumbing.NewHashReference(localRef, ref.Hash())); err != nil {
+		return errors.Wrap(err, "git checkout")
+	}
+	if err := w.Checkout(&gogit.CheckoutOptions{Branch: localRef, Force: true}); err != nil {
+		return errors.Wrap(err, "git checkout")
+	}
+	return errors.Wrap(w.Clean(&gogit.CleanOptions{Dir: true}), "git clean")
+}
+
+func (n *Native) CheckoutBranch(path, branch string) error {
+	r, w, err := open(path)
+	if err != nil {
+		return err
+	}
+	head, err := r.Head()
+	if err != nil {
+		return errors.Wrap(err, "git checkout")
+	}
+	ref := plumbing.NewBranchReferenceName(branch)
+	if err := r.Storer.SetReference(plumbing.NewHashReference(ref, head.Hash())); err != nil {
+		return errors.Wrap(err, "git checkout")
+	}
+	return errors.Wrap(w.Checkout(&gogit.CheckoutOptions{Branch: ref, Keep: true}), "git checkout")
+}
+
+func (n *Native) Commit(path, message string) error {
+	_, w, err := open(path)
+	if err != nil {
+		return err
+	}
+	status, err := w.Status()
+	if err != nil {
+		return errors.Wrap(err, "git status")
+	}
+	for file, s := range status {
+		if s.Worktree == gogit.Unmodified {
+			continue
+		}
+		if s.Worktree == gogit.Deleted {
+			_, err = w.Remove(file)
+		} else {
+			_, err = w.Add(file)
+		}
+		if err != nil {
+			return errors.Wrap(err, "git add")
+		}
+	}
+
+	author := n.Author
+	if author.Name == "" {
+		author = defaultAuthor
+	}
+	_, err = w.Commit(message, &gogit.CommitOptions{
+		Author: &object.Signature{Name: author.Name, Email: author.Email, When: time.Now()},
+	})
+	return errors.Wrap(err, "git commit")
+}
+
+func (n *Native) Push(path, repo, branch string) error {
+	r, _, err := open(path)
+	if err != nil {
+		return err
+	}
+	head, err := r.Head()
+	if err != nil {
+		return errors.Wrap(err, "git push")
+	}
+	auth, err := n.auth()
+	if err != nil {
+		return err
+	}
+	remote := gogit.NewRemote(r.Storer, &config.RemoteConfig{Name: "push", URLs: []string{repo}})
+	err = remote.Push(&gogit.PushOptions{
+		RemoteName: "push",
+		Auth:       auth,
+		RefSpecs:   []config.RefSpec{config.RefSpec(fmt.Sprintf("%s:%s", head.Name(), plumbing.NewBranchReferenceName(branch)))},
+	})
+	if err != nil && err != gogit.NoErrAlreadyUpToDate {
+		return errors.Wrap(err, "git push")
+	}
+	return nil
+}
+
+func (n *Native) HeadCommit(path string) (string, error) {
+	r, _, err := open(path)
+	if err != nil {
+		return "", err
+	}
+	head, err := r.Head()
+	if err != nil {
+		return "", errors.Wrap(err, "git rev-parse")
+	}
+	return head.Hash().String(), nil
+}
+
+func (n *Native) IsValid(url string) bool {
+	auth, err := n.auth()
+	if err != nil {
+		return false
+	}
+	remote := gogit.NewRemote(nil, &config.RemoteConfig{Name: "origin", URLs: []string{url}})
+	_, err = remote.List(&gogit.ListOptions{Auth: auth})
+	return err == nil
+}
+
+func (n *Native) auth() (transport.AuthMethod, error) {
+	if n.Auth.SSHKeyFile != "" {
+		user := n.Auth.Username
+		if user == "" {
+			user = "git"
+		}
+		keys, err := ssh.NewPublicKeysFromFile(user, n.Auth.SSHKeyFile, "")
+		if err != nil {
+			return nil, errors.Wrap(err, "load ssh key")
+		}
+		if n.Auth.KnownHostsFile != "" {
+			keys.HostKeyCallback, err = ssh.NewKnownHostsCallback(n.Auth.KnownHostsFile)
+			if err != nil {
+				return nil, errors.Wrap(err, "load ssh known hosts")
+			}
+		}
+		return keys, nil
+	}
+	if n.Auth.Password != "" {
+		user := n.Auth.Username
+		if user == "" {
+			//token authentication, most forges accept any user name with it.
+			user = "git"
+		}
+		return &http.BasicAuth{Username: user, Password: n.Auth.Password}, nil
+	}
+	return nil, nil
+}
+
+func open(path string) (*gogit.Repository, *gogit.Worktree, error) {
+	r, err := gogit.PlainOpen(path)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "git open")
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "git open")
+	}
+	return r, w, nil
+}
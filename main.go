@@ -36,6 +36,12 @@ func main() {
 		cmd.ServiceCommand(),
 		cmd.CatalogCommand(),
 		cmd.StackCommand(),
+		cmd.ReleaseCommand(),
+		cmd.ConfigCommand(),
+		cmd.ServeCommand(),
+		cmd.SyncCommand(),
+		cmd.ReconcileCommand(),
+		cmd.WatchCommand(),
 	}
 
 	err := app.Run(os.Args)
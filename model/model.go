@@ -1,5 +1,11 @@
 package model
 
+import (
+	"time"
+
+	"github.com/rancher/rancher-upgrader/answers"
+)
+
 //ServiceUpgrade config
 type ServiceUpgrade struct {
 	ServiceSelector map[string]string `json:"serviceSelector,omitempty" mapstructure:"serviceSelector"`
@@ -8,31 +14,86 @@ type ServiceUpgrade struct {
 	IntervalMillis  int64             `json:"intervalMillis,omitempty" mapstructure:"intervalMillis"`
 	StartFirst      bool              `json:"startFirst,omitempty" mapstructure:"startFirst"`
 	Type            string            `json:"type,omitempty" mapstructure:"type"`
+	WaitTimeout     time.Duration     `json:"waitTimeout,omitempty" mapstructure:"waitTimeout"`
 }
 
 //StackUpgrade config
 type StackUpgrade struct {
-	CattleUrl       string
-	AccessKey       string
-	SecretKey       string
-	ToLatestCatalog bool
-	StackName       string
-	DockerCompose   string
-	RancherCompose  string
-	ExternalId      string
-	Environment     map[string]interface{}
+	ToLatestCatalog  bool
+	InstallIfMissing bool
+	StackName        string
+	DockerCompose    string
+	RancherCompose   string
+	ExternalId       string
+	Environment      map[string]interface{}
+	Secrets          *answers.Secrets
+	Template         string
+	Parallelism      int
+	WaitTimeout      time.Duration
+}
+
+//AutoUpgrade config, the stacks following the latest version of their catalog template and
+//when they may be upgraded
+type AutoUpgrade struct {
+	StackNames  []string
+	Template    string
+	OptInLabel  string
+	Windows     []string
+	Location    *time.Location
+	MaxUpgrades int
+	WaitTimeout time.Duration
 }
 
 //CatalogUpgrade config
 type CatalogUpgrade struct {
+	CatalogName        string
 	GitUrl             string
 	GitBranch          string
 	TemplateFolderName string
 	TemplateIsSystem   bool
+	TemplateBase       string
+	Layout             string
+	ChartDir           string
 	CacheRoot          string
-	GitUser            string
-	GitPassword        string
 	DockerCompose      string
 	RancherCompose     string
 	Readme             string
+	SourceBuild        string
+	Forge              string
+	ForgeUrl           string
+	ForgeToken         string
+	PullRequestBranch  string
+	PushToBranch       bool
+	FromPrevious       bool
+	Variables          map[string]string
+}
+
+//SourceSync config, the branch of a source repo whose compose files are published to a catalog
+//template
+type SourceSync struct {
+	GitUrl    string
+	GitBranch string
+	Path      string
+	CacheRoot string
+}
+
+//DesiredStack is a stack as declared in a desired-state directory, deployed from compose files
+//or from a catalog template version
+type DesiredStack struct {
+	Name           string                 `yaml:"-"`
+	DockerCompose  string                 `yaml:"-"`
+	RancherCompose string                 `yaml:"-"`
+	ExternalId     string                 `yaml:"externalId,omitempty"`
+	Answers        map[string]interface{} `yaml:"answers,omitempty"`
+}
+
+//TemplateConfig is the config.yml of a catalog template
+type TemplateConfig struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description,omitempty"`
+	Version     string `yaml:"version,omitempty"`
+	Category    string `yaml:"category,omitempty"`
+	Maintainer  string `yaml:"maintainer,omitempty"`
+	License     string `yaml:"license,omitempty"`
+	ProjectURL  string `yaml:"projectURL,omitempty"`
 }
@@ -0,0 +1,118 @@
+package profile
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/mitchellh/go-homedir"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+//Profile is a named set of connection settings.
+type Profile struct {
+	Url         string        `yaml:"url,omitempty"`
+	Environment string        `yaml:"environment,omitempty"`
+	EnvUrl      string        `yaml:"envUrl,omitempty"`
+	AccessKey   string        `yaml:"accessKey,omitempty"`
+	SecretKey   string        `yaml:"secretKey,omitempty"`
+	Timeout     time.Duration `yaml:"timeout,omitempty"`
+	WaitTimeout time.Duration `yaml:"waitTimeout,omitempty"`
+
+	CACert             string `yaml:"caCert,omitempty"`
+	ClientCert         string `yaml:"clientCert,omitempty"`
+	ClientKey          string `yaml:"clientKey,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify,omitempty"`
+	Proxy              string `yaml:"proxy,omitempty"`
+}
+
+//Config is the config file holding the profiles.
+type Config struct {
+	Current  string              `yaml:"current,omitempty"`
+	Profiles map[string]*Profile `yaml:"profiles,omitempty"`
+}
+
+//DefaultPath is the config file in the home of the user, RANCHER_UPGRADER_CONFIG overrides it.
+func DefaultPath() (string, error) {
+	if path := os.Getenv("RANCHER_UPGRADER_CONFIG"); path != "" {
+		return path, nil
+	}
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".rancher-upgrader", "config.yml"), nil
+}
+
+//Load reads the config file, a missing file is an empty config. Config files others can read
+//are warned about since they hold API keys.
+func Load(path string) (*Config, error) {
+	config := &Config{Profiles: map[string]*Profile{}}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		logrus.Warnf("%s is accessible by other users, restrict it with chmod 600", path)
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(content, config); err != nil {
+		return nil, errors.Wrapf(err, "parse %s failed", path)
+	}
+	if config.Profiles == nil {
+		config.Profiles = map[string]*Profile{}
+	}
+	return config, nil
+}
+
+//Save writes the config file readable by the user only.
+func (c *Config) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	content, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+//Get returns the profile called name, or the current profile without a name. No profile is
+//returned when no name is given and none is current.
+func (c *Config) Get(name string) (*Profile, error) {
+	if name == "" {
+		name = c.Current
+	}
+	if name == "" {
+		return nil, nil
+	}
+	p, ok := c.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("profile '%s' not found, known profiles: %v", name, c.Names())
+	}
+	return p, nil
+}
+
+//Names lists the profile names in order.
+func (c *Config) Names() []string {
+	var names []string
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,53 @@
+package profile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "home", "config.yml")
+
+	config, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, err := config.Get(""); p != nil || err != nil {
+		t.Fatalf("expected no current profile, got %v, %v", p, err)
+	}
+	config.Profiles["prod"] = &Profile{Url: "http://rancher:8080", Environment: "prod", SecretKey: "secret", WaitTimeout: 10 * time.Minute}
+	config.Current = "prod"
+	if err := config.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600, got %v", info.Mode().Perm())
+	}
+
+	config, err = Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := config.Get("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Environment != "prod" || p.SecretKey != "secret" || p.WaitTimeout != 10*time.Minute {
+		t.Errorf("unexpected profile %+v", p)
+	}
+	if _, err := config.Get("staging"); err == nil {
+		t.Error("expected unknown profile to fail")
+	}
+}
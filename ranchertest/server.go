@@ -0,0 +1,552 @@
+//Package ranchertest runs an in-process Rancher server for tests. It serves the parts of the
+//v2-beta and v1-catalog APIs the upgrader uses for one environment: schemas, services, stacks
+//and containers with their upgrade, finishupgrade and rollback actions, the exportconfig action
+//of stacks and catalog template versions. Upgrades go through the transitioning states of
+//Rancher and can be scripted to be slow, to fail or to leave containers unhealthy.
+package ranchertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rancher/go-rancher/catalog"
+	"github.com/rancher/go-rancher/v2"
+)
+
+//ProjectId is the ID of the environment of the server.
+const ProjectId = "1a5"
+
+//Behavior scripts how upgrades of a service or stack go.
+type Behavior struct {
+	//Polls is how many times the resource is read while transitioning before it settles.
+	Polls int
+	//Fail makes the upgrade end in error with this message.
+	Fail string
+	//Unhealthy leaves the containers of an upgraded service unhealthy.
+	Unhealthy bool
+}
+
+//Server is the fake Rancher server, start it with NewServer and Close it when done.
+type Server struct {
+	*httptest.Server
+
+	//AccessKey and SecretKey are required from clients when set.
+	AccessKey string
+	SecretKey string
+	//PageSize splits collections into pages when set.
+	PageSize int
+
+	mu         sync.Mutex
+	services   []*client.Service
+	stacks     []*client.Stack
+	containers []*client.Container
+	templates  map[string]*catalog.TemplateVersion
+	behaviors  map[string]Behavior
+	pending    map[string]*transition
+	actions    []string
+	refreshes  int
+	nextId     int
+}
+
+//transition is an action of a resource in progress.
+type transition struct {
+	polls    int
+	behavior Behavior
+	settled  string
+	previous interface{}
+}
+
+//NewServer starts a server with an empty environment.
+func NewServer() *Server {
+	s := &Server{
+		templates: map[string]*catalog.TemplateVersion{},
+		behaviors: map[string]Behavior{},
+		pending:   map[string]*transition{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+//EnvUrl is the API endpoint of the environment, what --envurl takes.
+func (s *Server) EnvUrl() string {
+	return s.URL + "/v2-beta/projects/" + ProjectId
+}
+
+//AddService adds an active service and returns it as the API serves it.
+func (s *Server) AddService(service client.Service) client.Service {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextId++
+	service.Id = fmt.Sprintf("1s%d", s.nextId)
+	service.Type = "service"
+	s.links(&service.Resource, "services")
+	if service.State == "" {
+		service.State = "active"
+	}
+	service.Transitioning = "no"
+	if service.HealthState == "" {
+		service.HealthState = "healthy"
+	}
+	s.services = append(s.services, &service)
+	return service
+}
+
+//AddStack adds an active stack and returns it as the API serves it.
+func (s *Server) AddStack(stack client.Stack) client.Stack {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addStack(&stack)
+	return stack
+}
+
+func (s *Server) addStack(stack *client.Stack) {
+	s.nextId++
+	stack.Id = fmt.Sprintf("1st%d", s.nextId)
+	stack.Type = "stack"
+	s.links(&stack.Resource, "stacks")
+	if stack.State == "" {
+		stack.State = "active"
+	}
+	stack.Transitioning = "no"
+	s.stacks = append(s.stacks, stack)
+}
+
+//AddContainer adds a running container, ServiceIds tie it to services.
+func (s *Server) AddContainer(container client.Container) client.Container {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextId++
+	container.Id = fmt.Sprintf("1i%d", s.nextId)
+	container.Type = "container"
+	s.links(&container.Resource, "containers")
+	if container.State == "" {
+		container.State = "running"
+	}
+	if container.HealthState == "" {
+		container.HealthState = "healthy"
+	}
+	s.containers = append(s.containers, &container)
+	return container
+}
+
+//AddTemplateVersion makes the catalog serve a template version, id is catalog:template:version
+//as in external IDs. Upgrade links are given as the IDs of the versions to upgrade to.
+func (s *Server) AddTemplateVersion(id string, tv catalog.TemplateVersion, upgradesTo ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tv.Id = id
+	tv.Type = "templateVersion"
+	if len(upgradesTo) > 0 {
+		tv.UpgradeVersionLinks = map[string]interface{}{}
+		for _, to := range upgradesTo {
+			tv.UpgradeVersionLinks[to] = s.URL + "/v1-catalog/templates/" + to
+		}
+	}
+	s.templates[id] = &tv
+}
+
+//SetBehavior scripts the upgrades of the service or stack called name.
+func (s *Server) SetBehavior(name string, b Behavior) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.behaviors[name] = b
+}
+
+//Service returns the service called name as it is now.
+func (s *Server) Service(name string) (client.Service, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, service := range s.services {
+		if service.Name == name {
+			return *service, true
+		}
+	}
+	return client.Service{}, false
+}
+
+//Stack returns the stack called name as it is now.
+func (s *Server) Stack(name string) (client.Stack, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, stack := range s.stacks {
+		if stack.Name == name {
+			return *stack, true
+		}
+	}
+	return client.Stack{}, false
+}
+
+//Containers returns the containers of the service.
+func (s *Server) Containers(serviceId string) []client.Container {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var containers []client.Container
+	for _, c := range s.containers {
+		for _, id := range c.ServiceIds {
+			if id == serviceId {
+				containers = append(containers, *c)
+			}
+		}
+	}
+	return containers
+}
+
+//Actions lists the actions run so far as "<action> <name>", e.g. "upgrade web".
+func (s *Server) Actions() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.actions...)
+}
+
+//Refreshes is how many times the catalog was refreshed.
+func (s *Server) Refreshes() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.refreshes
+}
+
+func (s *Server) links(r *client.Resource, collection string) {
+	self := fmt.Sprintf("%s/%s/%s", s.EnvUrl(), collection, r.Id)
+	r.Links = map[string]string{"self": self}
+	r.Actions = map[string]string{}
+	for _, action := range []string{"upgrade", "finishupgrade", "rollback"} {
+		r.Actions[action] = self + "?action=" + action
+	}
+	if collection == "stacks" {
+		r.Actions["exportconfig"] = self + "?action=exportconfig"
+	}
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if s.AccessKey != "" || s.SecretKey != "" {
+		if ak, sk, _ := r.BasicAuth(); ak != s.AccessKey || sk != s.SecretKey {
+			writeError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	envPath := "/v2-beta/projects/" + ProjectId
+	switch {
+	case path == "/v2-beta" || path == "/v2-beta/schemas":
+		w.Header().Set("X-API-Schemas", s.URL+"/v2-beta/schemas")
+		writeJSON(w, s.schemas(s.URL+"/v2-beta", "project"))
+	case path == "/v2-beta/projects":
+		writeJSON(w, client.ProjectCollection{Collection: collection("project"), Data: []client.Project{s.project()}})
+	case path == envPath:
+		w.Header().Set("X-API-Schemas", s.EnvUrl()+"/schemas")
+		w.Header().Set("X-Api-User-Id", ProjectId)
+		writeJSON(w, s.project())
+	case path == envPath+"/schemas":
+		w.Header().Set("X-API-Schemas", s.EnvUrl()+"/schemas")
+		writeJSON(w, s.schemas(s.EnvUrl(), "service", "stack", "container"))
+	case strings.HasPrefix(path, envPath+"/"):
+		s.serveResource(w, r, strings.Split(strings.TrimPrefix(path, envPath+"/"), "/"))
+	case path == "/v1-catalog/templates" && r.Method == "POST" && r.URL.Query().Get("action") == "refresh":
+		s.refreshes++
+		w.WriteHeader(http.StatusNoContent)
+	case strings.HasPrefix(path, "/v1-catalog/templates/") && r.Method == "GET":
+		tv, ok := s.templates[strings.TrimPrefix(path, "/v1-catalog/templates/")]
+		if !ok {
+			writeError(w, http.StatusNotFound, "template version not found")
+			return
+		}
+		writeJSON(w, tv)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (s *Server) serveResource(w http.ResponseWriter, r *http.Request, parts []string) {
+	switch {
+	case len(parts) == 1 && r.Method == "GET":
+		s.serveCollection(w, r, parts[0])
+	case len(parts) == 1 && r.Method == "POST" && parts[0] == "stacks":
+		stack := &client.Stack{}
+		if err := json.NewDecoder(r.Body).Decode(stack); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.addStack(stack)
+		s.actions = append(s.actions, "create "+stack.Name)
+		stack.State = "activating"
+		s.start(stack.Id, stack.Name, "active", nil, &stack.State, &stack.Transitioning, &stack.TransitioningMessage)
+		writeJSON(w, stack)
+	case len(parts) == 2 && r.Method == "GET":
+		resource := s.find(parts[0], parts[1])
+		if resource == nil {
+			writeError(w, http.StatusNotFound, "not found")
+			return
+		}
+		s.poll(resource)
+		writeJSON(w, resource)
+	case len(parts) == 2 && r.Method == "POST" && r.URL.Query().Get("action") != "":
+		s.serveAction(w, r, parts[0], parts[1], r.URL.Query().Get("action"))
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *Server) serveCollection(w http.ResponseWriter, r *http.Request, kind string) {
+	start, _ := strconv.Atoi(r.URL.Query().Get("marker"))
+	page := func(total int) (int, int, *client.Pagination) {
+		if start > total {
+			start = total
+		}
+		end := total
+		if s.PageSize > 0 && start+s.PageSize < total {
+			end = start + s.PageSize
+			return start, end, &client.Pagination{Next: fmt.Sprintf("%s/%s?marker=%d", s.EnvUrl(), kind, end)}
+		}
+		return start, end, nil
+	}
+	switch kind {
+	case "services":
+		from, to, pagination := page(len(s.services))
+		c := client.ServiceCollection{Collection: collection("service")}
+		c.Pagination = pagination
+		for _, service := range s.services[from:to] {
+			s.poll(service)
+			c.Data = append(c.Data, *service)
+		}
+		writeJSON(w, c)
+	case "stacks":
+		from, to, pagination := page(len(s.stacks))
+		c := client.StackCollection{Collection: collection("stack")}
+		c.Pagination = pagination
+		for _, stack := range s.stacks[from:to] {
+			s.poll(stack)
+			c.Data = append(c.Data, *stack)
+		}
+		writeJSON(w, c)
+	case "containers":
+		from, to, pagination := page(len(s.containers))
+		c := client.ContainerCollection{Collection: collection("container")}
+		c.Pagination = pagination
+		for _, container := range s.containers[from:to] {
+			c.Data = append(c.Data, *container)
+		}
+		writeJSON(w, c)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (s *Server) serveAction(w http.ResponseWriter, r *http.Request, kind, id, action string) {
+	switch resource := s.find(kind, id).(type) {
+	case *client.Service:
+		if resource.Transitioning == "yes" {
+			writeError(w, http.StatusConflict, "service is transitioning")
+			return
+		}
+		s.actions = append(s.actions, action+" "+resource.Name)
+		switch action {
+		case "upgrade":
+			upgrade := &client.ServiceUpgrade{}
+			if err := json.NewDecoder(r.Body).Decode(upgrade); err != nil || upgrade.InServiceStrategy == nil {
+				writeError(w, http.StatusUnprocessableEntity, "inServiceStrategy is required")
+				return
+			}
+			previous := *resource
+			if lc := upgrade.InServiceStrategy.LaunchConfig; lc != nil {
+				resource.LaunchConfig = lc
+			}
+			if upgrade.InServiceStrategy.SecondaryLaunchConfigs != nil {
+				resource.SecondaryLaunchConfigs = upgrade.InServiceStrategy.SecondaryLaunchConfigs
+			}
+			resource.Upgrade = upgrade
+			resource.State = "upgrading"
+			s.start(resource.Id, resource.Name, "upgraded", &previous, &resource.State, &resource.Transitioning, &resource.TransitioningMessage)
+		case "finishupgrade":
+			if resource.State != "upgraded" {
+				writeError(w, http.StatusConflict, "service is "+resource.State)
+				return
+			}
+			resource.State, resource.Upgrade = "active", nil
+			delete(s.pending, resource.Id)
+		case "rollback":
+			if t, ok := s.pending[resource.Id]; ok && t.previous != nil {
+				previous := t.previous.(*client.Service)
+				resource.LaunchConfig = previous.LaunchConfig
+				resource.SecondaryLaunchConfigs = previous.SecondaryLaunchConfigs
+				delete(s.pending, resource.Id)
+			}
+			resource.State, resource.Transitioning, resource.TransitioningMessage, resource.Upgrade = "active", "no", "", nil
+			s.setHealth(resource.Id, "healthy")
+		default:
+			writeError(w, http.StatusNotFound, "unknown action "+action)
+			return
+		}
+		writeJSON(w, resource)
+	case *client.Stack:
+		if action == "exportconfig" {
+			writeJSON(w, client.ComposeConfig{
+				Resource:             client.Resource{Type: "composeConfig"},
+				DockerComposeConfig:  resource.DockerCompose,
+				RancherComposeConfig: resource.RancherCompose,
+			})
+			return
+		}
+		if resource.Transitioning == "yes" {
+			writeError(w, http.StatusConflict, "stack is transitioning")
+			return
+		}
+		s.actions = append(s.actions, action+" "+resource.Name)
+		switch action {
+		case "upgrade":
+			upgrade := &client.StackUpgrade{}
+			if err := json.NewDecoder(r.Body).Decode(upgrade); err != nil {
+				writeError(w, http.StatusUnprocessableEntity, err.Error())
+				return
+			}
+			previous := *resource
+			resource.PreviousExternalId, resource.PreviousEnvironment = resource.ExternalId, resource.Environment
+			resource.DockerCompose, resource.RancherCompose = upgrade.DockerCompose, upgrade.RancherCompose
+			resource.ExternalId, resource.Environment = upgrade.ExternalId, upgrade.Environment
+			resource.State = "upgrading"
+			s.start(resource.Id, resource.Name, "upgraded", &previous, &resource.State, &resource.Transitioning, &resource.TransitioningMessage)
+		case "finishupgrade":
+			if resource.State != "upgraded" {
+				writeError(w, http.StatusConflict, "stack is "+resource.State)
+				return
+			}
+			resource.State = "active"
+			delete(s.pending, resource.Id)
+		case "rollback":
+			if t, ok := s.pending[resource.Id]; ok && t.previous != nil {
+				previous := t.previous.(*client.Stack)
+				resource.DockerCompose, resource.RancherCompose = previous.DockerCompose, previous.RancherCompose
+				resource.ExternalId, resource.Environment = previous.ExternalId, previous.Environment
+				delete(s.pending, resource.Id)
+			}
+			resource.State, resource.Transitioning, resource.TransitioningMessage = "active", "no", ""
+		default:
+			writeError(w, http.StatusNotFound, "unknown action "+action)
+			return
+		}
+		writeJSON(w, resource)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+//start begins a transition of the resource to settled, following the behavior of its name.
+func (s *Server) start(id, name, settled string, previous interface{}, state, transitioning, message *string) {
+	t := &transition{polls: s.behaviors[name].Polls, behavior: s.behaviors[name], settled: settled, previous: previous}
+	s.pending[id] = t
+	*transitioning, *message = "yes", "in progress"
+	if t.polls == 0 {
+		s.settle(id, t, state, transitioning, message)
+	}
+}
+
+//poll counts a read of a transitioning resource and settles it after enough reads.
+func (s *Server) poll(resource interface{}) {
+	switch r := resource.(type) {
+	case *client.Service:
+		if t, ok := s.pending[r.Id]; ok && r.Transitioning == "yes" {
+			if t.polls--; t.polls <= 0 {
+				s.settle(r.Id, t, &r.State, &r.Transitioning, &r.TransitioningMessage)
+			}
+		}
+	case *client.Stack:
+		if t, ok := s.pending[r.Id]; ok && r.Transitioning == "yes" {
+			if t.polls--; t.polls <= 0 {
+				s.settle(r.Id, t, &r.State, &r.Transitioning, &r.TransitioningMessage)
+			}
+		}
+	}
+}
+
+func (s *Server) settle(id string, t *transition, state, transitioning, message *string) {
+	if t.behavior.Fail != "" {
+		*transitioning, *message = "error", t.behavior.Fail
+		return
+	}
+	*state, *transitioning, *message = t.settled, "no", ""
+	if t.behavior.Unhealthy {
+		s.setHealth(id, "unhealthy")
+	}
+}
+
+func (s *Server) setHealth(serviceId, health string) {
+	for _, service := range s.services {
+		if service.Id == serviceId {
+			service.HealthState = health
+		}
+	}
+	for _, c := range s.containers {
+		for _, id := range c.ServiceIds {
+			if id == serviceId {
+				c.HealthState = health
+			}
+		}
+	}
+}
+
+func (s *Server) find(kind, id string) interface{} {
+	switch kind {
+	case "services":
+		for _, service := range s.services {
+			if service.Id == id {
+				return service
+			}
+		}
+	case "stacks":
+		for _, stack := range s.stacks {
+			if stack.Id == id {
+				return stack
+			}
+		}
+	case "containers":
+		for _, container := range s.containers {
+			if container.Id == id {
+				return container
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Server) project() client.Project {
+	return client.Project{
+		Resource: client.Resource{Id: ProjectId, Type: "project", Links: map[string]string{"self": s.EnvUrl()}},
+		Name:     "Default",
+		State:    "active",
+	}
+}
+
+func (s *Server) schemas(base string, types ...string) client.Schemas {
+	schemas := client.Schemas{Collection: collection("schema")}
+	for _, t := range types {
+		schemas.Data = append(schemas.Data, client.Schema{
+			Resource:          client.Resource{Id: t, Type: "schema", Links: map[string]string{"collection": base + "/" + t + "s"}},
+			PluralName:        t + "s",
+			CollectionMethods: []string{"GET", "POST"},
+			ResourceMethods:   []string{"GET", "PUT", "DELETE"},
+		})
+	}
+	return schemas
+}
+
+func collection(resourceType string) client.Collection {
+	return client.Collection{Type: "collection", ResourceType: resourceType}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"type": "error", "status": status, "message": message})
+}
@@ -0,0 +1,108 @@
+package ranchertest
+
+import (
+	"testing"
+
+	"github.com/rancher/go-rancher/v2"
+)
+
+func newClient(t *testing.T, s *Server) *client.RancherClient {
+	apiClient, err := client.NewRancherClient(&client.ClientOpts{Url: s.EnvUrl() + "/schemas", AccessKey: s.AccessKey, SecretKey: s.SecretKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return apiClient
+}
+
+func TestServiceUpgrade(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	web := s.AddService(client.Service{Name: "web", LaunchConfig: &client.LaunchConfig{ImageUuid: "docker:web:1"}})
+	s.AddContainer(client.Container{Name: "web-1", ServiceIds: []string{web.Id}})
+	s.SetBehavior("web", Behavior{Polls: 2, Unhealthy: true})
+	apiClient := newClient(t, s)
+
+	upgraded, err := apiClient.Service.ActionUpgrade(&web, &client.ServiceUpgrade{
+		InServiceStrategy: &client.InServiceUpgradeStrategy{LaunchConfig: &client.LaunchConfig{ImageUuid: "docker:web:2"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var states []string
+	for i := 0; i < 3; i++ {
+		states = append(states, upgraded.State+"/"+upgraded.Transitioning)
+		if err := apiClient.Reload(&upgraded.Resource, upgraded); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := []string{"upgrading/yes", "upgrading/yes", "upgraded/no"}
+	for i := range expected {
+		if states[i] != expected[i] {
+			t.Fatalf("expected states %v, got %v", expected, states)
+		}
+	}
+	if containers := s.Containers(web.Id); containers[0].HealthState != "unhealthy" {
+		t.Errorf("expected the containers to be unhealthy, got %s", containers[0].HealthState)
+	}
+
+	if _, err := apiClient.Service.ActionRollback(upgraded); err != nil {
+		t.Fatal(err)
+	}
+	service, _ := s.Service("web")
+	if service.LaunchConfig.ImageUuid != "docker:web:1" || service.State != "active" || service.HealthState != "healthy" {
+		t.Errorf("expected rollback to web:1, got %s %s %s", service.LaunchConfig.ImageUuid, service.State, service.HealthState)
+	}
+	if actions := s.Actions(); len(actions) != 2 || actions[0] != "upgrade web" || actions[1] != "rollback web" {
+		t.Errorf("unexpected actions %v", actions)
+	}
+}
+
+func TestStackUpgradeFailure(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	stack := s.AddStack(client.Stack{Name: "web", ExternalId: "catalog://lib:web:1"})
+	s.SetBehavior("web", Behavior{Fail: "image pull failed"})
+	apiClient := newClient(t, s)
+
+	upgraded, err := apiClient.Stack.ActionUpgrade(&stack, &client.StackUpgrade{ExternalId: "catalog://lib:web:2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upgraded.Transitioning != "error" || upgraded.TransitioningMessage != "image pull failed" {
+		t.Errorf("expected the upgrade to fail, got %s %s", upgraded.Transitioning, upgraded.TransitioningMessage)
+	}
+	if _, err := apiClient.Stack.ActionFinishupgrade(upgraded); err == nil {
+		t.Error("expected finishing a failed upgrade to be refused")
+	}
+	if previous := upgraded.PreviousExternalId; previous != "catalog://lib:web:1" {
+		t.Errorf("expected previous external ID lib:web:1, got %s", previous)
+	}
+}
+
+func TestPagingAndAuth(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.AccessKey, s.SecretKey, s.PageSize = "ak", "sk", 2
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		s.AddStack(client.Stack{Name: name})
+	}
+
+	if _, err := client.NewRancherClient(&client.ClientOpts{Url: s.EnvUrl() + "/schemas", AccessKey: "ak", SecretKey: "wrong"}); err == nil {
+		t.Error("expected wrong keys to be refused")
+	}
+	apiClient := newClient(t, s)
+	var names []string
+	stacks, err := apiClient.Stack.List(&client.ListOpts{})
+	for stacks != nil && err == nil {
+		for _, stack := range stacks.Data {
+			names = append(names, stack.Name)
+		}
+		stacks, err = stacks.Next()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 5 {
+		t.Errorf("expected 5 stacks over 3 pages, got %v", names)
+	}
+}
@@ -0,0 +1,239 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+	"github.com/rancher/go-rancher/catalog"
+	"github.com/rancher/go-rancher/v2"
+)
+
+//RancherAPI is what the upgrader does in a Rancher environment, the services and stacks of the
+//environment and the catalog templates they are deployed from.
+type RancherAPI interface {
+	ListServices() ([]client.Service, error)
+	GetService(id string) (*client.Service, error)
+	UpgradeService(service *client.Service, upgrade *client.ServiceUpgrade) (*client.Service, error)
+	FinishServiceUpgrade(service *client.Service) (*client.Service, error)
+	RollbackService(service *client.Service) (*client.Service, error)
+	//ReloadService updates service with its current state.
+	ReloadService(service *client.Service) error
+
+	ListStacks() ([]client.Stack, error)
+	GetStack(id string) (*client.Stack, error)
+	CreateStack(stack *client.Stack) (*client.Stack, error)
+	UpgradeStack(stack *client.Stack, upgrade *client.StackUpgrade) (*client.Stack, error)
+	FinishStackUpgrade(stack *client.Stack) (*client.Stack, error)
+	RollbackStack(stack *client.Stack) (*client.Stack, error)
+	//ReloadStack updates stack with its current state.
+	ReloadStack(stack *client.Stack) error
+	//ExportStackConfig returns the compose files of the stack as it is deployed.
+	ExportStackConfig(stack *client.Stack) (*client.ComposeConfig, error)
+
+	//TemplateVersion looks up a catalog template version by its catalog:// external ID.
+	TemplateVersion(externalId string) (*catalog.TemplateVersion, error)
+	//RefreshCatalog makes the catalogs of the environment pull their repos.
+	RefreshCatalog() error
+}
+
+//rancherAPI is the RancherAPI of go-rancher, catalog templates are served by the v1-catalog API
+//of the server.
+type rancherAPI struct {
+	client    *client.RancherClient
+	cattleUrl string
+	accessKey string
+	secretKey string
+	timeout   time.Duration
+
+	mu        sync.Mutex
+	projectId string
+}
+
+//NewRancherAPI connects to the environment at cattleUrl, its API endpoint.
+func NewRancherAPI(cattleUrl, accessKey, secretKey string, timeout time.Duration) (RancherAPI, error) {
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	apiClient, err := client.NewRancherClient(&client.ClientOpts{
+		Timeout:   timeout,
+		Url:       cattleUrl + "/schemas",
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &rancherAPI{
+		client:    apiClient,
+		cattleUrl: cattleUrl,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		timeout:   timeout,
+	}, nil
+}
+
+func (r *rancherAPI) ListServices() ([]client.Service, error) {
+	var services []client.Service
+	collection, err := r.client.Service.List(&client.ListOpts{})
+	for collection != nil && err == nil {
+		services = append(services, collection.Data...)
+		collection, err = collection.Next()
+	}
+	return services, err
+}
+
+func (r *rancherAPI) GetService(id string) (*client.Service, error) {
+	return r.client.Service.ById(id)
+}
+
+func (r *rancherAPI) UpgradeService(service *client.Service, upgrade *client.ServiceUpgrade) (*client.Service, error) {
+	return r.client.Service.ActionUpgrade(service, upgrade)
+}
+
+func (r *rancherAPI) FinishServiceUpgrade(service *client.Service) (*client.Service, error) {
+	return r.client.Service.ActionFinishupgrade(service)
+}
+
+func (r *rancherAPI) RollbackService(service *client.Service) (*client.Service, error) {
+	return r.client.Service.ActionRollback(service)
+}
+
+func (r *rancherAPI) ReloadService(service *client.Service) error {
+	return r.client.Reload(&service.Resource, service)
+}
+
+func (r *rancherAPI) ListStacks() ([]client.Stack, error) {
+	var stacks []client.Stack
+	collection, err := r.client.Stack.List(&client.ListOpts{})
+	for collection != nil && err == nil {
+		stacks = append(stacks, collection.Data...)
+		collection, err = collection.Next()
+	}
+	return stacks, err
+}
+
+func (r *rancherAPI) GetStack(id string) (*client.Stack, error) {
+	return r.client.Stack.ById(id)
+}
+
+func (r *rancherAPI) CreateStack(stack *client.Stack) (*client.Stack, error) {
+	return r.client.Stack.Create(stack)
+}
+
+func (r *rancherAPI) UpgradeStack(stack *client.Stack, upgrade *client.StackUpgrade) (*client.Stack, error) {
+	return r.client.Stack.ActionUpgrade(stack, upgrade)
+}
+
+func (r *rancherAPI) FinishStackUpgrade(stack *client.Stack) (*client.Stack, error) {
+	return r.client.Stack.ActionFinishupgrade(stack)
+}
+
+func (r *rancherAPI) RollbackStack(stack *client.Stack) (*client.Stack, error) {
+	return r.client.Stack.ActionRollback(stack)
+}
+
+func (r *rancherAPI) ReloadStack(stack *client.Stack) error {
+	return r.client.Reload(&stack.Resource, stack)
+}
+
+func (r *rancherAPI) ExportStackConfig(stack *client.Stack) (*client.ComposeConfig, error) {
+	return r.client.Stack.ActionExportconfig(stack, &client.ComposeConfigInput{})
+}
+
+func (r *rancherAPI) TemplateVersion(externalId string) (*catalog.TemplateVersion, error) {
+	trimExternalId := externalId[strings.LastIndex(externalId, "/")+1:]
+	requestURL, err := r.catalogURL("/v1-catalog/templates/"+trimExternalId, "")
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.do("GET", requestURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	byteContent, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get template version %s failed: %s", trimExternalId, resp.Status)
+	}
+	tempObj := &catalog.TemplateVersion{}
+	if err := json.Unmarshal(byteContent, tempObj); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("getTemplateLatestVersion error, Failed to parse: %s", byteContent))
+	}
+	return tempObj, nil
+}
+
+func (r *rancherAPI) RefreshCatalog() error {
+	refreshUrl, err := r.catalogURL("/v1-catalog/templates", "action=refresh&")
+	if err != nil {
+		return err
+	}
+	return r.client.Post(refreshUrl, nil, nil)
+}
+
+//catalogURL is path of the v1-catalog API of the server, scoped to the environment.
+func (r *rancherAPI) catalogURL(path, query string) (string, error) {
+	u, err := url.Parse(r.cattleUrl)
+	if err != nil {
+		return "", err
+	}
+	projId, err := r.getProjId()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s://%s%s?%sprojectId=%s", u.Scheme, u.Host, path, query, projId), nil
+}
+
+//getProjId is the ID of the environment, taken from its endpoint URL or asked from the server.
+func (r *rancherAPI) getProjId() (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.projectId != "" {
+		return r.projectId, nil
+	}
+	if m := regProjectUrl.FindStringSubmatch(r.cattleUrl); m != nil {
+		r.projectId = m[1]
+		return r.projectId, nil
+	}
+
+	resp, err := r.do("GET", r.cattleUrl)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	userid := resp.Header.Get("X-Api-User-Id")
+	if userid == "" {
+		log.Infoln("Cannot get userid")
+		err := errors.New("Forbidden")
+		return "Forbidden", err
+
+	}
+	r.projectId = userid
+	return userid, nil
+}
+
+func (r *rancherAPI) do(method, requestURL string) (*http.Response, error) {
+	req, err := http.NewRequest(method, requestURL, nil)
+	if err != nil {
+		log.Infoln("Cannot connect to the rancher server. Please check the rancher server URL")
+		return nil, err
+	}
+	req.SetBasicAuth(r.accessKey, r.secretKey)
+	resp, err := (&http.Client{Timeout: r.timeout}).Do(req)
+	if err != nil {
+		log.Infoln("Cannot connect to the rancher server. Please check the rancher server URL")
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rancher/go-rancher/v2"
+	"github.com/rancher/rancher-upgrader/model"
+)
+
+//StackResult is the outcome of upgrading one stack in a batch.
+type StackResult struct {
+	Stack string
+	Err   error
+}
+
+//UpgradeTemplateStacks upgrades every stack deployed from config.Template to its latest
+//template version, running up to config.Parallelism upgrades at once. Each stack keeps its
+//own answers, config.Environment is applied on top of them.
+func UpgradeTemplateStacks(api RancherAPI, config *model.StackUpgrade) ([]StackResult, error) {
+	if config.Secrets != nil {
+		env, err := config.Secrets.ResolveAll(config.Environment)
+		if err != nil {
+			return nil, err
+		}
+		config.Environment = env
+	}
+
+	stacks, err := TemplateStacks(api, config.Template)
+	if err != nil {
+		return nil, err
+	}
+	if len(stacks) == 0 {
+		log.Infof("no stack is deployed from template '%s'", config.Template)
+		return nil, nil
+	}
+
+	log.Infoln("refreshing catalog templates...")
+	if err := api.RefreshCatalog(); err != nil {
+		return nil, err
+	}
+
+	return upgradeStacks(api, config, stacks, "")
+}
+
+//upgradeStacks upgrades the stacks to the template version externalId, or to their latest
+//template version without one, running up to config.Parallelism upgrades at once.
+func upgradeStacks(api RancherAPI, config *model.StackUpgrade, stacks []client.Stack, externalId string) ([]StackResult, error) {
+	parallelism := config.Parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	results := make([]StackResult, len(stacks))
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i := range stacks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			results[i].Stack = stacks[i].Name
+			if externalId != "" && stacks[i].ExternalId == externalId {
+				log.Infof("stack '%s' is at %s already", stacks[i].Name, externalId)
+				return
+			}
+			stackConfig := *config
+			stackConfig.StackName = stacks[i].Name
+			stackConfig.ToLatestCatalog = externalId == ""
+			stackConfig.ExternalId = externalId
+			stackConfig.DockerCompose = ""
+			stackConfig.RancherCompose = ""
+			results[i].Err = upgradeFoundStack(api, &stackConfig, &stacks[i])
+		}(i)
+	}
+	wg.Wait()
+
+	var failed []string
+	for _, r := range results {
+		if r.Err != nil {
+			failed = append(failed, r.Stack)
+		}
+	}
+	if len(failed) > 0 {
+		return results, fmt.Errorf("%d of %d stacks failed to upgrade: %s", len(failed), len(results), strings.Join(failed, ","))
+	}
+	return results, nil
+}
+
+//TemplateStacks lists the stacks whose ExternalId references the catalog template, given as
+//<catalog>:<template> or <catalog>:<templateBase>*<template>.
+func TemplateStacks(api RancherAPI, template string) ([]client.Stack, error) {
+	catalogName, templateName, templateBase, _, ok := TemplateURLPath(strings.TrimPrefix(template, "catalog://"))
+	if !ok {
+		return nil, fmt.Errorf("invalid catalog template '%s', needs the form 'catalog:template'", template)
+	}
+
+	stacks, err := api.ListStacks()
+	if err != nil {
+		log.Errorf("Error %v in listing stacks", err)
+		return nil, err
+	}
+	var matched []client.Stack
+	for _, stack := range stacks {
+		if !strings.HasPrefix(stack.ExternalId, "catalog://") {
+			continue
+		}
+		c, t, b, _, ok := TemplateURLPath(strings.TrimPrefix(stack.ExternalId, "catalog://"))
+		if ok && c == catalogName && t == templateName && b == templateBase {
+			matched = append(matched, stack)
+		}
+	}
+	return matched, nil
+}
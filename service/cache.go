@@ -0,0 +1,119 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+)
+
+const lockSuffix = ".lock"
+
+//lockCache takes an exclusive lock on a cache entry so that jobs sharing a cache root don't
+//work in the same clone at once. With wait false it fails instead of waiting for the lock.
+//The lock file's modification time records when the entry was last used.
+func lockCache(repoPath string, wait bool) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(repoPath), 0755); err != nil {
+		return nil, errors.Wrap(err, "mkdir failed")
+	}
+	f, _, err := openCacheLock(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	if wait {
+		logrus.Debugf("waiting for cache lock %s", f.Name())
+	}
+	if err := flockCache(f, wait); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	os.Chtimes(f.Name(), now, now)
+	return f, nil
+}
+
+//openCacheLock opens the lock file of a cache entry, created tells if it didn't exist before.
+func openCacheLock(repoPath string) (*os.File, bool, error) {
+	f, err := os.OpenFile(repoPath+lockSuffix, os.O_RDWR, 0644)
+	if os.IsNotExist(err) {
+		if f, err = os.OpenFile(repoPath+lockSuffix, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0644); err == nil {
+			return f, true, nil
+		}
+		if os.IsExist(err) {
+			f, err = os.OpenFile(repoPath+lockSuffix, os.O_RDWR, 0644)
+		}
+	}
+	if err != nil {
+		return nil, false, errors.Wrap(err, "open cache lock failed")
+	}
+	return f, false, nil
+}
+
+//flockCache locks the opened lock file, closing it when that fails.
+func flockCache(f *os.File, wait bool) error {
+	how := syscall.LOCK_EX
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+	if err := syscall.Flock(int(f.Fd()), how); err != nil {
+		f.Close()
+		return errors.Wrap(err, "lock cache failed")
+	}
+	return nil
+}
+
+func unlockCache(f *os.File) {
+	syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	f.Close()
+}
+
+//PruneCache removes the cached catalog repos under cacheRoot not used for olderThan. Entries
+//locked by a running job are skipped. It returns the removed cache directories.
+func PruneCache(cacheRoot string, olderThan time.Duration) ([]string, error) {
+	files, err := ioutil.ReadDir(cacheRoot)
+	if err != nil {
+		return nil, err
+	}
+	var pruned []string
+	for _, f := range files {
+		if !f.IsDir() {
+			continue
+		}
+		repoPath := filepath.Join(cacheRoot, f.Name())
+		lock, created, err := openCacheLock(repoPath)
+		if err != nil {
+			return pruned, err
+		}
+		if err := flockCache(lock, false); err != nil {
+			logrus.Infof("skipping cache %s in use", repoPath)
+			continue
+		}
+		//last use is read under the lock, a job may have used the entry until just now. An
+		//entry without lock file yet was last used when its directory changed.
+		lastUsed := f.ModTime()
+		if !created {
+			info, err := lock.Stat()
+			if err != nil {
+				unlockCache(lock)
+				return pruned, errors.Wrap(err, "stat cache lock failed")
+			}
+			lastUsed = info.ModTime()
+		}
+		if time.Since(lastUsed) < olderThan {
+			unlockCache(lock)
+			continue
+		}
+
+		//the lock file stays, removing it would race with jobs waiting for it.
+		err = os.RemoveAll(repoPath)
+		unlockCache(lock)
+		if err != nil {
+			return pruned, errors.Wrap(err, "remove cache failed")
+		}
+		pruned = append(pruned, repoPath)
+	}
+	return pruned, nil
+}
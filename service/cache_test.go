@@ -0,0 +1,67 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPruneCache(t *testing.T) {
+	root, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	old := time.Now().Add(-2 * time.Hour)
+	for _, name := range []string{"locked", "nolock", "old", "recent"} {
+		if err := os.Mkdir(filepath.Join(root, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filepath.Join(root, name), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, used := range map[string]time.Time{"locked": old, "old": old, "recent": time.Now().Add(-time.Minute)} {
+		if err := ioutil.WriteFile(filepath.Join(root, name)+lockSuffix, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filepath.Join(root, name)+lockSuffix, used, used); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//a running job holds the lock of an entry otherwise old enough to prune.
+	lock, err := lockCache(filepath.Join(root, "locked"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(lock.Name(), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	pruned, err := PruneCache(root, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := filepath.Join(root, "nolock") + "," + filepath.Join(root, "old")
+	if strings.Join(pruned, ",") != expected {
+		t.Errorf("expected %s pruned, got %v", expected, pruned)
+	}
+	for _, name := range []string{"locked", "recent"} {
+		if _, err := os.Stat(filepath.Join(root, name)); err != nil {
+			t.Errorf("expected %s to be kept, got %v", name, err)
+		}
+	}
+
+	//an entry a job just used is recent again.
+	unlockCache(lock)
+	if lock, err = lockCache(filepath.Join(root, "locked"), false); err != nil {
+		t.Fatal(err)
+	}
+	unlockCache(lock)
+	if pruned, err := PruneCache(root, time.Hour); err != nil || len(pruned) != 0 {
+		t.Errorf("expected a just used entry to be kept, got %v %v", pruned, err)
+	}
+}
@@ -3,17 +3,18 @@ package service
 import (
 	"crypto/md5"
 	"encoding/hex"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
 	"strconv"
-	"strings"
 
 	"github.com/Sirupsen/logrus"
 
 	"github.com/pkg/errors"
+	"github.com/rancher/rancher-upgrader/forge"
 	"github.com/rancher/rancher-upgrader/git"
 	"github.com/rancher/rancher-upgrader/model"
 )
@@ -45,17 +46,37 @@ func UpgradeCatalog(config *model.CatalogUpgrade) error {
 		catalog, _ := client.Catalog.ById("")
 		template, _ := client.Template.ById("")
 	*/
-	repoPath, _, err := prepareGitRepoPath(config)
+	_, err := PublishTemplateVersion(config)
+	return err
+}
+
+//PublishTemplateVersion adds a new version of the template to the catalog repo and returns its
+//version folder.
+func PublishTemplateVersion(config *model.CatalogUpgrade) (string, error) {
+	version := ""
+	err := withCatalogRepo(config, func(repoPath string) error {
+		var err error
+		version, err = generateNewTemplateVersion(repoPath, config)
+		return err
+	})
+	return version, err
+}
+
+//withCatalogRepo runs f on the up to date cached clone of the catalog repo, holding the cache
+//lock for the whole run.
+func withCatalogRepo(config *model.CatalogUpgrade, f func(repoPath string) error) error {
+	lock, err := lockCache(cachePath(config), true)
 	if err != nil {
-		logrus.Errorf("Prepare Git repo path got error:%v", err)
 		return err
 	}
+	defer unlockCache(lock)
 
-	if err := generateNewTemplateVersion(repoPath, config); err != nil {
+	repoPath, _, err := prepareGitRepoPath(config)
+	if err != nil {
+		logrus.Errorf("Prepare Git repo path got error:%v", err)
 		return err
 	}
-
-	return nil
+	return f(repoPath)
 }
 
 func dirEmpty(dir string) (bool, error) {
@@ -71,8 +92,8 @@ func dirEmpty(dir string) (bool, error) {
 	return false, err
 }
 
-//
-func prepareGitRepoPath(config *model.CatalogUpgrade) (string, string, error) {
+//cachePath is where the catalog repo branch is cached under the cache root.
+func cachePath(config *model.CatalogUpgrade) string {
 	branch := config.GitBranch
 	if config.GitBranch == "" {
 		branch = "master"
@@ -80,14 +101,35 @@ func prepareGitRepoPath(config *model.CatalogUpgrade) (string, string, error) {
 
 	sum := md5.Sum([]byte(config.GitUrl + branch))
 	repoBranchHash := hex.EncodeToString(sum[:])
-	repoPath := path.Join(config.CacheRoot, repoBranchHash)
+	return path.Join(config.CacheRoot, repoBranchHash)
+}
 
-	if err := os.MkdirAll(repoPath, 0755); err != nil {
-		return "", "", errors.Wrap(err, "mkdir failed")
+//prepareGitRepoPath clones the catalog repo into the cache on first use and otherwise brings
+//the cached clone up to date with the remote branch. The cache entry must be locked.
+func prepareGitRepoPath(config *model.CatalogUpgrade) (string, string, error) {
+	branch := config.GitBranch
+	if config.GitBranch == "" {
+		branch = "master"
 	}
+	repoPath := cachePath(config)
 
-	if err := git.Clone(repoPath, config.GitUrl, branch); err != nil {
-		return "", "", errors.Wrap(err, "Clone failed")
+	if _, err := os.Stat(filepath.Join(repoPath, ".git")); err == nil {
+		if err := git.Update(repoPath, branch); err != nil {
+			return "", "", errors.Wrap(err, "Update failed")
+		}
+	} else {
+		//a leftover of an interrupted clone, start over.
+		if empty, err := dirEmpty(repoPath); err == nil && !empty {
+			if err := os.RemoveAll(repoPath); err != nil {
+				return "", "", errors.Wrap(err, "remove broken cache failed")
+			}
+		}
+		if err := os.MkdirAll(repoPath, 0755); err != nil {
+			return "", "", errors.Wrap(err, "mkdir failed")
+		}
+		if err := git.Clone(repoPath, config.GitUrl, branch); err != nil {
+			return "", "", errors.Wrap(err, "Clone failed")
+		}
 	}
 
 	commit, err := git.HeadCommit(repoPath)
@@ -97,59 +139,142 @@ func prepareGitRepoPath(config *model.CatalogUpgrade) (string, string, error) {
 	return repoPath, commit, err
 }
 
-func generateNewTemplateVersion(repoPath string, config *model.CatalogUpgrade) error {
+func generateNewTemplateVersion(repoPath string, config *model.CatalogUpgrade) (string, error) {
+	if err := locateTemplate(repoPath, config); err != nil {
+		return "", err
+	}
+	templatePath := templateFolderPath(repoPath, config)
 
-	templatePath := ""
-	if config.TemplateIsSystem == false {
-		templatePath = filepath.Join(repoPath, "templates", config.TemplateFolderName)
-	} else {
-		templatePath = filepath.Join(repoPath, "infra-templates", config.TemplateFolderName)
+	if config.Layout == LayoutHelm {
+		version, err := generateNewChartVersion(templatePath, config)
+		if err != nil {
+			logrus.Errorf("prepare new chart version got error: %v", err)
+			return "", err
+		}
+		return version, publishTemplateVersion(repoPath, version, config)
 	}
 
+	if _, err := os.Stat(templatePath); os.IsNotExist(err) {
+		return "", fmt.Errorf("template %s does not exist, create it with catalog init", catalogTemplateId(config))
+	}
 	lv, err := GetLatestVersion(templatePath)
 
 	if err != nil {
 		logrus.Errorf("get template version error: %v", err)
-		return err
+		return "", err
 	}
 	newV := lv + 1
 
+	if config.FromPrevious {
+		if err = renderFromPrevious(templatePath, lv, config); err != nil {
+			logrus.Errorf("render new template version got error: %v", err)
+			return "", err
+		}
+	}
+
+	if err = ValidateTemplateVersion(templatePath, -1, config.DockerCompose, config.RancherCompose); err != nil {
+		logrus.Errorf("validate new template version got error: %v", err)
+		return "", err
+	}
+
 	if err = os.Mkdir(filepath.Join(templatePath, strconv.Itoa(newV)), 0755); err != nil {
 		logrus.Errorf("prepare new template version got error: %v", err)
-		return err
+		return "", err
 	}
 
-	if err = ioutil.WriteFile(filepath.Join(templatePath, strconv.Itoa(newV), "docker-compose.yml"), []byte(config.DockerCompose), 0755); err != nil {
+	if err = ioutil.WriteFile(filepath.Join(templatePath, strconv.Itoa(newV), "docker-compose.yml"), []byte(config.DockerCompose), 0644); err != nil {
 		logrus.Errorf("prepare new template version got error: %v", err)
-		return err
+		return "", err
 	}
 
-	if err = ioutil.WriteFile(filepath.Join(templatePath, strconv.Itoa(newV), "rancher-compose.yml"), []byte(config.RancherCompose), 0755); err != nil {
+	if err = ioutil.WriteFile(filepath.Join(templatePath, strconv.Itoa(newV), "rancher-compose.yml"), []byte(config.RancherCompose), 0644); err != nil {
 		logrus.Errorf("prepare new template version got error: %v", err)
-		return err
+		return "", err
 	}
 
 	if config.Readme != "" {
-		if err = ioutil.WriteFile(filepath.Join(templatePath, strconv.Itoa(newV), "README.md"), []byte(config.Readme), 0755); err != nil {
+		if err = ioutil.WriteFile(filepath.Join(templatePath, strconv.Itoa(newV), "README.md"), []byte(config.Readme), 0644); err != nil {
 			logrus.Errorf("prepare new template version got error: %v", err)
-			return err
+			return "", err
 		}
 	}
 
-	repoUrl := config.GitUrl
-	if strings.HasPrefix(repoUrl, "https") {
-		if config.GitUser != "" && config.GitPassword != "" {
-			repoUrl = strings.Replace(repoUrl, "https://", "https://"+config.GitUser+":"+config.GitPassword+"@", 1)
-		} else {
-			logrus.Fatalf("username/password for git repo not provided.\n")
+	return strconv.Itoa(newV), publishTemplateVersion(repoPath, strconv.Itoa(newV), config)
+}
+
+//publishTemplateVersion commits the new version and pushes it, either straight to the catalog
+//branch or to a feature branch with a pull request opened through the configured forge.
+func publishTemplateVersion(repoPath, version string, config *model.CatalogUpgrade) error {
+	title := fmt.Sprintf("%s: add version %s", config.TemplateFolderName, version)
+	return publishChange(repoPath, title, fmt.Sprintf("rancher-upgrader/%s-%s", config.TemplateFolderName, version), config)
+}
+
+//publishChange commits all changes of the catalog repo with title. They are pushed straight to
+//the catalog branch only with config.PushToBranch, otherwise to featureBranch, unless
+//config.PullRequestBranch names another one, and a pull request is opened when a forge is
+//configured.
+func publishChange(repoPath, title, featureBranch string, config *model.CatalogUpgrade) error {
+	branch := config.GitBranch
+	if branch == "" {
+		branch = "master"
+	}
+	message := title
+	if config.SourceBuild != "" {
+		message += "\n\nSource: " + config.SourceBuild
+	}
+
+	if config.PushToBranch {
+		if config.Forge != "" {
+			return errors.New("pushing to the catalog branch and opening a pull request exclude each other")
 		}
+		if err := git.Commit(repoPath, message); err != nil {
+			return errors.Wrap(err, "commit template version failed")
+		}
+		if err := git.Push(repoPath, config.GitUrl, branch); err != nil {
+			return errors.Wrap(err, "push template version failed")
+		}
+		logrus.Infof("published '%s' to branch %s", title, branch)
+		return nil
 	}
 
-	if err = git.LazyPush(templatePath, repoUrl, config.GitBranch); err != nil {
-		logrus.Errorf("prepare new template version got error: %v", err)
+	var f forge.Forge
+	var repo string
+	if config.Forge != "" {
+		var err error
+		if f, err = forge.New(config.Forge, config.ForgeUrl, config.ForgeToken); err != nil {
+			return err
+		}
+		if repo, err = forge.RepoPath(config.GitUrl); err != nil {
+			return err
+		}
+	}
+	if config.PullRequestBranch != "" {
+		featureBranch = config.PullRequestBranch
+	}
+	if err := git.CheckoutBranch(repoPath, featureBranch); err != nil {
+		return errors.Wrap(err, "create feature branch failed")
+	}
+	if err := git.Commit(repoPath, message); err != nil {
+		return errors.Wrap(err, "commit template version failed")
+	}
+	if err := git.Push(repoPath, config.GitUrl, featureBranch); err != nil {
+		return errors.Wrap(err, "push feature branch failed")
+	}
+	if f == nil {
+		logrus.Warnf("pushed '%s' to branch %s, no pull request is opened without a forge", title, featureBranch)
+		return nil
+	}
+	prUrl, err := f.CreatePullRequest(&forge.PullRequest{
+		Repo:  repo,
+		Head:  featureBranch,
+		Base:  branch,
+		Title: title,
+		Body:  message,
+	})
+	if err != nil {
 		return err
 	}
-
+	logrus.Infof("opened pull request for '%s': %s", title, prUrl)
 	return nil
 }
 
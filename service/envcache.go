@@ -0,0 +1,95 @@
+package service
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+//EnvironmentCache remembers the environments names were resolved to, per server, in a file.
+type EnvironmentCache struct {
+	File string
+
+	mu      sync.Mutex
+	entries map[string]EnvTarget
+}
+
+func (c *EnvironmentCache) key(serverUrl, name string) string {
+	return strings.TrimRight(serverUrl, "/") + " " + name
+}
+
+func (c *EnvironmentCache) load() {
+	if c.entries != nil {
+		return
+	}
+	c.entries = map[string]EnvTarget{}
+	content, err := ioutil.ReadFile(c.File)
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(content, &c.entries); err != nil {
+		log.Debugf("ignoring broken environment cache %s: %v", c.File, err)
+		c.entries = map[string]EnvTarget{}
+	}
+}
+
+func (c *EnvironmentCache) save() error {
+	if err := os.MkdirAll(filepath.Dir(c.File), 0700); err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(c.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.File, content, 0600)
+}
+
+//Get returns the cached environment of name on the server.
+func (c *EnvironmentCache) Get(serverUrl, name string) (EnvTarget, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+	env, ok := c.entries[c.key(serverUrl, name)]
+	return env, ok
+}
+
+//Put caches the environment name resolved to on the server.
+func (c *EnvironmentCache) Put(serverUrl, name string, env EnvTarget) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+	c.entries[c.key(serverUrl, name)] = env
+	return c.save()
+}
+
+//stillValid checks the cached environment still exists under the name it was cached for.
+func stillValid(env EnvTarget, name, accessKey, secretKey string) bool {
+	req, err := http.NewRequest("GET", env.Url, nil)
+	if err != nil {
+		return false
+	}
+	req.SetBasicAuth(accessKey, secretKey)
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+	project := struct {
+		Id   string `json:"id"`
+		Name string `json:"name"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&project); err != nil {
+		return false
+	}
+	return project.Id == name || project.Name == name
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+	"github.com/rancher/go-rancher/v2"
+)
+
+//EnvTarget is a Rancher environment (project) an upgrade runs in.
+type EnvTarget struct {
+	Name string `json:"name"`
+	Url  string `json:"url"`
+}
+
+//EnvResult is the outcome of running in one environment.
+type EnvResult struct {
+	Env string
+	Err error
+}
+
+//ResolveEnvironments looks up the environments of the server with account level keys, projects
+//are given by name or ID and returned in the given order. Environments found in cache are
+//used as long as they still exist under their name.
+func ResolveEnvironments(serverUrl, accessKey, secretKey string, projects []string, cache *EnvironmentCache) ([]EnvTarget, error) {
+	envs := make([]EnvTarget, len(projects))
+	var missing []int
+	for i, p := range projects {
+		if cache != nil {
+			if env, ok := cache.Get(serverUrl, p); ok && stillValid(env, p, accessKey, secretKey) {
+				envs[i] = env
+				continue
+			}
+		}
+		missing = append(missing, i)
+	}
+	if len(missing) == 0 {
+		return envs, nil
+	}
+
+	apiClient, err := client.NewRancherClient(&client.ClientOpts{
+		Url:       serverUrl,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "connect to rancher server failed")
+	}
+	available, err := listProjects(apiClient)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, i := range missing {
+		found := false
+		for _, project := range available {
+			if project.Id == projects[i] || project.Name == projects[i] {
+				if found {
+					return nil, fmt.Errorf("environment name '%s' is ambiguous, give its ID instead", projects[i])
+				}
+				envs[i] = EnvTarget{Name: project.Name, Url: project.Links["self"]}
+				found = true
+			}
+		}
+		if !found {
+			var names []string
+			for _, project := range available {
+				names = append(names, fmt.Sprintf("%s (%s)", project.Name, project.Id))
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("environment '%s' not found on %s, available environments: %s", projects[i], serverUrl, strings.Join(names, ", "))
+		}
+		if cache != nil {
+			if err := cache.Put(serverUrl, projects[i], envs[i]); err != nil {
+				log.Warnf("caching environment %s failed: %v", projects[i], err)
+			}
+		}
+	}
+	return envs, nil
+}
+
+func listProjects(apiClient *client.RancherClient) ([]client.Project, error) {
+	var projects []client.Project
+	collection, err := apiClient.Project.List(&client.ListOpts{})
+	for collection != nil && err == nil {
+		projects = append(projects, collection.Data...)
+		collection, err = collection.Next()
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "list environments failed")
+	}
+	return projects, nil
+}
+
+//RunEnvironments runs f in every environment, all at once when parallel is set and otherwise
+//one after another in order. Sequential runs stop at the first failure unless continueOnError
+//is set, the environments left out are reported as skipped.
+func RunEnvironments(envs []EnvTarget, parallel, continueOnError bool, f func(env EnvTarget) error) ([]EnvResult, error) {
+	results := make([]EnvResult, len(envs))
+	skipped := 0
+	if parallel {
+		var wg sync.WaitGroup
+		for i := range envs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i] = EnvResult{Env: envs[i].Name, Err: f(envs[i])}
+			}(i)
+		}
+		wg.Wait()
+	} else {
+		failed := ""
+		for i, env := range envs {
+			results[i].Env = env.Name
+			if failed != "" {
+				results[i].Err = fmt.Errorf("skipped after %s failed", failed)
+				skipped++
+				continue
+			}
+			log.Infof("upgrading environment %s", env.Name)
+			results[i].Err = f(env)
+			if results[i].Err != nil && !continueOnError {
+				failed = env.Name
+			}
+		}
+	}
+
+	var failed []string
+	for _, r := range results[:len(results)-skipped] {
+		if r.Err != nil {
+			failed = append(failed, r.Env)
+		}
+	}
+	if len(failed) == 0 {
+		return results, nil
+	}
+	err := fmt.Errorf("%d of %d environments failed: %s", len(failed), len(results), strings.Join(failed, ","))
+	if skipped > 0 {
+		err = fmt.Errorf("%v, %d skipped", err, skipped)
+	}
+	return results, err
+}
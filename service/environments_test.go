@@ -0,0 +1,90 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunEnvironments(t *testing.T) {
+	envs := []EnvTarget{{Name: "staging"}, {Name: "prod-eu"}, {Name: "prod-us"}}
+	var ran []string
+	f := func(env EnvTarget) error {
+		ran = append(ran, env.Name)
+		if env.Name == "prod-eu" {
+			return errors.New("boom")
+		}
+		return nil
+	}
+
+	results, err := RunEnvironments(envs, false, false, f)
+	if err == nil || err.Error() != "1 of 3 environments failed: prod-eu, 1 skipped" {
+		t.Errorf("unexpected error %v", err)
+	}
+	if strings.Join(ran, ",") != "staging,prod-eu" || results[2].Err == nil {
+		t.Errorf("expected prod-us to be skipped, ran %v", ran)
+	}
+
+	ran = nil
+	if _, err := RunEnvironments(envs, false, true, f); err == nil || len(ran) != 3 {
+		t.Errorf("expected all environments to run, ran %v (%v)", ran, err)
+	}
+}
+
+func TestResolveEnvironments(t *testing.T) {
+	lists := 0
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2-beta/projects/1a7" {
+			w.Write([]byte(`{"id":"1a7","name":"prod"}`))
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/projects") {
+			lists++
+			fmt.Fprintf(w, `{"type":"collection","data":[
+				{"id":"1a5","name":"staging","links":{"self":"%[1]s/v2-beta/projects/1a5"}},
+				{"id":"1a7","name":"prod","links":{"self":"%[1]s/v2-beta/projects/1a7"}}]}`, server.URL)
+			return
+		}
+		w.Header().Set("X-API-Schemas", server.URL+"/v2-beta/schemas")
+		fmt.Fprintf(w, `{"type":"collection","data":[{"id":"project","collectionMethods":["GET"],"links":{"collection":"%s/v2-beta/projects"}}]}`, server.URL)
+	}))
+	defer server.Close()
+
+	envs, err := ResolveEnvironments(server.URL, "", "", []string{"prod", "1a5"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(envs) != 2 || envs[0].Name != "prod" || envs[1].Url != server.URL+"/v2-beta/projects/1a5" {
+		t.Errorf("unexpected environments %+v", envs)
+	}
+	if _, err := ResolveEnvironments(server.URL, "", "", []string{"dev"}, nil); err == nil || !strings.Contains(err.Error(), "available environments: prod (1a7), staging (1a5)") {
+		t.Errorf("expected error listing the environments, got %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "envcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cache := &EnvironmentCache{File: filepath.Join(dir, "environments.json")}
+	lists = 0
+	for i := 0; i < 2; i++ {
+		envs, err := ResolveEnvironments(server.URL, "", "", []string{"prod"}, cache)
+		if err != nil || envs[0].Url != server.URL+"/v2-beta/projects/1a7" {
+			t.Fatalf("unexpected environments %+v (%v)", envs, err)
+		}
+	}
+	if lists != 1 {
+		t.Errorf("expected the second lookup to use the cache, listed environments %d times", lists)
+	}
+	if info, err := os.Stat(cache.File); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expected cache file with mode 0600, got %v (%v)", info, err)
+	}
+}
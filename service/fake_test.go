@@ -0,0 +1,235 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/rancher/go-rancher/catalog"
+	"github.com/rancher/go-rancher/v2"
+)
+
+//fakeAPI is an in-memory environment. Upgrades finish at once, services and stacks named in
+//fail end up in error instead.
+type fakeAPI struct {
+	mu        sync.Mutex
+	services  []client.Service
+	stacks    []client.Stack
+	templates map[string]*catalog.TemplateVersion
+	fail      map[string]bool
+	refreshes int
+	calls     []string
+}
+
+func (f *fakeAPI) record(format string, args ...interface{}) {
+	f.calls = append(f.calls, fmt.Sprintf(format, args...))
+}
+
+func (f *fakeAPI) service(id string) (*client.Service, error) {
+	for i := range f.services {
+		if f.services[i].Id == id {
+			return &f.services[i], nil
+		}
+	}
+	return nil, fmt.Errorf("service %s not found", id)
+}
+
+func (f *fakeAPI) stack(id string) (*client.Stack, error) {
+	for i := range f.stacks {
+		if f.stacks[i].Id == id {
+			return &f.stacks[i], nil
+		}
+	}
+	return nil, fmt.Errorf("stack %s not found", id)
+}
+
+//transition moves a service or stack to the state an upgrade ends in.
+func (f *fakeAPI) transition(name string, state, transitioning, message *string) {
+	if f.fail[name] {
+		*state, *transitioning, *message = "upgrading", "error", "upgrade failed"
+		return
+	}
+	*state, *transitioning = "upgraded", "no"
+}
+
+func (f *fakeAPI) ListServices() ([]client.Service, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]client.Service(nil), f.services...), nil
+}
+
+func (f *fakeAPI) GetService(id string) (*client.Service, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, err := f.service(id)
+	if err != nil {
+		return nil, err
+	}
+	copy := *s
+	return &copy, nil
+}
+
+func (f *fakeAPI) UpgradeService(service *client.Service, upgrade *client.ServiceUpgrade) (*client.Service, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, err := f.service(service.Id)
+	if err != nil {
+		return nil, err
+	}
+	f.record("upgrade service %s", s.Name)
+	if lc := upgrade.InServiceStrategy.LaunchConfig; lc != nil {
+		s.LaunchConfig = lc
+	}
+	if upgrade.InServiceStrategy.SecondaryLaunchConfigs != nil {
+		s.SecondaryLaunchConfigs = upgrade.InServiceStrategy.SecondaryLaunchConfigs
+	}
+	f.transition(s.Name, &s.State, &s.Transitioning, &s.TransitioningMessage)
+	copy := *s
+	return &copy, nil
+}
+
+func (f *fakeAPI) FinishServiceUpgrade(service *client.Service) (*client.Service, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, err := f.service(service.Id)
+	if err != nil {
+		return nil, err
+	}
+	f.record("finish service %s", s.Name)
+	s.State = "active"
+	copy := *s
+	return &copy, nil
+}
+
+func (f *fakeAPI) RollbackService(service *client.Service) (*client.Service, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, err := f.service(service.Id)
+	if err != nil {
+		return nil, err
+	}
+	f.record("rollback service %s", s.Name)
+	s.State, s.Transitioning = "active", "no"
+	copy := *s
+	return &copy, nil
+}
+
+func (f *fakeAPI) ReloadService(service *client.Service) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, err := f.service(service.Id)
+	if err != nil {
+		return err
+	}
+	*service = *s
+	return nil
+}
+
+func (f *fakeAPI) ListStacks() ([]client.Stack, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]client.Stack(nil), f.stacks...), nil
+}
+
+func (f *fakeAPI) GetStack(id string) (*client.Stack, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, err := f.stack(id)
+	if err != nil {
+		return nil, err
+	}
+	copy := *s
+	return &copy, nil
+}
+
+func (f *fakeAPI) CreateStack(stack *client.Stack) (*client.Stack, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("create stack %s", stack.Name)
+	created := *stack
+	created.Id = fmt.Sprintf("1st%d", len(f.stacks)+1)
+	created.State, created.Transitioning = "active", "no"
+	f.stacks = append(f.stacks, created)
+	return &created, nil
+}
+
+func (f *fakeAPI) UpgradeStack(stack *client.Stack, upgrade *client.StackUpgrade) (*client.Stack, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, err := f.stack(stack.Id)
+	if err != nil {
+		return nil, err
+	}
+	f.record("upgrade stack %s to %s", s.Name, upgrade.ExternalId)
+	s.DockerCompose = upgrade.DockerCompose
+	s.RancherCompose = upgrade.RancherCompose
+	s.ExternalId = upgrade.ExternalId
+	s.Environment = upgrade.Environment
+	f.transition(s.Name, &s.State, &s.Transitioning, &s.TransitioningMessage)
+	copy := *s
+	return &copy, nil
+}
+
+func (f *fakeAPI) FinishStackUpgrade(stack *client.Stack) (*client.Stack, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, err := f.stack(stack.Id)
+	if err != nil {
+		return nil, err
+	}
+	f.record("finish stack %s", s.Name)
+	s.State = "active"
+	copy := *s
+	return &copy, nil
+}
+
+func (f *fakeAPI) RollbackStack(stack *client.Stack) (*client.Stack, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, err := f.stack(stack.Id)
+	if err != nil {
+		return nil, err
+	}
+	f.record("rollback stack %s", s.Name)
+	s.State, s.Transitioning = "active", "no"
+	copy := *s
+	return &copy, nil
+}
+
+func (f *fakeAPI) ReloadStack(stack *client.Stack) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, err := f.stack(stack.Id)
+	if err != nil {
+		return err
+	}
+	*stack = *s
+	return nil
+}
+
+func (f *fakeAPI) ExportStackConfig(stack *client.Stack) (*client.ComposeConfig, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, err := f.stack(stack.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &client.ComposeConfig{DockerComposeConfig: s.DockerCompose, RancherComposeConfig: s.RancherCompose}, nil
+}
+
+func (f *fakeAPI) TemplateVersion(externalId string) (*catalog.TemplateVersion, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	tv, ok := f.templates[strings.TrimPrefix(externalId, "catalog://")]
+	if !ok {
+		return nil, fmt.Errorf("get template version %s failed: 404 Not Found", externalId)
+	}
+	return tv, nil
+}
+
+func (f *fakeAPI) RefreshCatalog() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.refreshes++
+	return nil
+}
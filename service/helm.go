@@ -0,0 +1,179 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+	"github.com/rancher/rancher-upgrader/model"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var regNumber = regexp.MustCompile(`\d+`)
+
+type chartSpec struct {
+	Name       string `yaml:"name"`
+	Version    string `yaml:"version"`
+	AppVersion string `yaml:"appVersion"`
+}
+
+//generateNewChartVersion adds a version of the chart at chartPath, copied from config.ChartDir
+//or from the latest version with config.FromPrevious, and returns the new version. The
+//"version" and "appVersion" variables set the fields of Chart.yaml, the version is bumped
+//from the previous one otherwise.
+func generateNewChartVersion(chartPath string, config *model.CatalogUpgrade) (string, error) {
+	source := config.ChartDir
+	previous, err := latestChartVersion(chartPath)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if config.FromPrevious {
+		if previous == "" {
+			return "", errors.New("chart has no version to start from")
+		}
+		source = filepath.Join(chartPath, previous)
+	}
+	if source == "" {
+		return "", errors.New("a chart directory is required for the helm layout")
+	}
+
+	chart, err := readChart(source)
+	if err != nil {
+		return "", err
+	}
+	version := config.Variables["version"]
+	if version == "" && config.FromPrevious {
+		if version, err = bumpVersion(chart.Version); err != nil {
+			return "", err
+		}
+	} else if version == "" {
+		version = chart.Version
+	}
+	for k := range config.Variables {
+		if k != "version" && k != "appVersion" {
+			return "", fmt.Errorf("variable '%s' is not supported for helm charts, only version and appVersion", k)
+		}
+	}
+
+	if err := validateChart(chartPath, chart, version, config.TemplateFolderName); err != nil {
+		return "", err
+	}
+	versionPath := filepath.Join(chartPath, version)
+	if err := copyDir(source, versionPath); err != nil {
+		os.RemoveAll(versionPath)
+		return "", errors.Wrap(err, "copy chart failed")
+	}
+
+	chartFile := filepath.Join(versionPath, "Chart.yaml")
+	fields := map[string]string{"version": version}
+	if appVersion, ok := config.Variables["appVersion"]; ok {
+		fields["appVersion"] = appVersion
+	}
+	for k, v := range fields {
+		if err := setTopLevelField(chartFile, k, v); err != nil {
+			return "", err
+		}
+	}
+	logrus.Infof("adding version %s of chart %s", version, config.TemplateFolderName)
+	return version, nil
+}
+
+//validateChart checks the chart before its version is added, its name has to match the chart
+//folder and the version must not exist yet.
+func validateChart(chartPath string, chart *chartSpec, version, name string) error {
+	var problems []string
+	if chart.Name != name {
+		problems = append(problems, fmt.Sprintf("Chart.yaml: name '%s' doesn't match chart folder '%s'", chart.Name, name))
+	}
+	if version == "" {
+		problems = append(problems, "Chart.yaml has no version")
+	} else if _, err := os.Stat(filepath.Join(chartPath, version)); err == nil {
+		problems = append(problems, fmt.Sprintf("version %s exists already", version))
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func readChart(chartDir string) (*chartSpec, error) {
+	content, err := ioutil.ReadFile(filepath.Join(chartDir, "Chart.yaml"))
+	if err != nil {
+		return nil, errors.Wrap(err, "read Chart.yaml failed")
+	}
+	chart := &chartSpec{}
+	if err := yaml.Unmarshal(content, chart); err != nil {
+		return nil, errors.Wrap(err, "parse Chart.yaml failed")
+	}
+	return chart, nil
+}
+
+//latestChartVersion returns the highest version folder of the chart.
+func latestChartVersion(chartPath string) (string, error) {
+	files, err := ioutil.ReadDir(chartPath)
+	if err != nil {
+		return "", err
+	}
+	latest := ""
+	for _, f := range files {
+		if !f.IsDir() || !regNumber.MatchString(f.Name()) {
+			continue
+		}
+		if latest == "" || versionLess(latest, f.Name()) {
+			latest = f.Name()
+		}
+	}
+	return latest, nil
+}
+
+//versionLess compares the numbers of two versions in order, 1.2.10 is after 1.2.9.
+func versionLess(a, b string) bool {
+	an, bn := regNumber.FindAllString(a, -1), regNumber.FindAllString(b, -1)
+	for i := 0; i < len(an) && i < len(bn); i++ {
+		x, _ := strconv.Atoi(an[i])
+		y, _ := strconv.Atoi(bn[i])
+		if x != y {
+			return x < y
+		}
+	}
+	if len(an) != len(bn) {
+		return len(an) < len(bn)
+	}
+	return a < b
+}
+
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rancher/rancher-upgrader/model"
+)
+
+const (
+	//LayoutRancher is the Rancher catalog layout, <base>-templates/<template>/<n>.
+	LayoutRancher = "rancher"
+	//LayoutHelm is the Helm chart repo layout, charts/<chart>/<version>.
+	LayoutHelm = "helm"
+)
+
+//templateBase is the base of the template, "" for templates/ and e.g. "infra" for
+//infra-templates/, as it appears in base*template catalog ids.
+func templateBase(config *model.CatalogUpgrade) string {
+	if config.TemplateBase == "" && config.TemplateIsSystem {
+		return "infra"
+	}
+	return config.TemplateBase
+}
+
+//baseFolder is the catalog repo folder holding the templates of base.
+func baseFolder(base string) string {
+	if base == "" {
+		return "templates"
+	}
+	return base + "-templates"
+}
+
+//templateFolderPath is the folder of config.TemplateFolderName in the catalog repo.
+func templateFolderPath(repoPath string, config *model.CatalogUpgrade) string {
+	if config.Layout == LayoutHelm {
+		return filepath.Join(repoPath, "charts", config.TemplateFolderName)
+	}
+	return filepath.Join(repoPath, baseFolder(templateBase(config)), config.TemplateFolderName)
+}
+
+//catalogBases lists the template bases of the catalog repo, the folders named templates or
+//<base>-templates.
+func catalogBases(repoPath string) ([]string, error) {
+	files, err := ioutil.ReadDir(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	var bases []string
+	for _, f := range files {
+		if !f.IsDir() {
+			continue
+		}
+		if f.Name() == "templates" {
+			bases = append(bases, "")
+		} else if strings.HasSuffix(f.Name(), "-templates") {
+			bases = append(bases, strings.TrimSuffix(f.Name(), "-templates"))
+		}
+	}
+	sort.Strings(bases)
+	return bases, nil
+}
+
+//LocateTemplate finds where config.TemplateFolderName lives in the catalog repo, see
+//locateTemplate.
+func LocateTemplate(config *model.CatalogUpgrade) error {
+	return withCatalogRepo(config, func(repoPath string) error {
+		return locateTemplate(repoPath, config)
+	})
+}
+
+//locateTemplate splits a template given as base*template and, when no base is set, looks for
+//the template in every base of the catalog repo. config is updated with the base found.
+func locateTemplate(repoPath string, config *model.CatalogUpgrade) error {
+	if config.TemplateFolderName == "" {
+		return fmt.Errorf("no template folder name given")
+	}
+	if config.Layout == LayoutHelm {
+		return nil
+	}
+	if i := strings.Index(config.TemplateFolderName, "*"); i >= 0 {
+		config.TemplateBase = config.TemplateFolderName[:i]
+		config.TemplateFolderName = config.TemplateFolderName[i+1:]
+		config.TemplateIsSystem = config.TemplateBase == "infra"
+		return nil
+	}
+	if config.TemplateBase != "" || config.TemplateIsSystem {
+		return nil
+	}
+
+	bases, err := catalogBases(repoPath)
+	if err != nil {
+		return err
+	}
+	var found []string
+	for _, base := range bases {
+		if _, err := os.Stat(filepath.Join(repoPath, baseFolder(base), config.TemplateFolderName)); err == nil {
+			found = append(found, base)
+		}
+	}
+	switch len(found) {
+	case 0:
+		//a new template goes to templates/.
+		return nil
+	case 1:
+		config.TemplateBase = found[0]
+		config.TemplateIsSystem = found[0] == "infra"
+		return nil
+	default:
+		var folders []string
+		for _, base := range found {
+			folders = append(folders, baseFolder(base))
+		}
+		return fmt.Errorf("template %s exists in %s, give its base as <base>*%s", config.TemplateFolderName, strings.Join(folders, ", "), config.TemplateFolderName)
+	}
+}
+
+//catalogTemplateId is the template part of the ExternalId of stacks deployed from the template.
+func catalogTemplateId(config *model.CatalogUpgrade) string {
+	if base := templateBase(config); base != "" {
+		return base + "*" + config.TemplateFolderName
+	}
+	return config.TemplateFolderName
+}
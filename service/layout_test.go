@@ -0,0 +1,87 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/rancher-upgrader/model"
+)
+
+func TestLocateTemplate(t *testing.T) {
+	repo, err := ioutil.TempDir("", "catalog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(repo)
+	for _, dir := range []string{"templates/web", "kubernetes-templates/dns", "infra-templates/dns", "swarm-templates/portainer"} {
+		if err := os.MkdirAll(filepath.Join(repo, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := &model.CatalogUpgrade{TemplateFolderName: "portainer"}
+	if err := locateTemplate(repo, config); err != nil || config.TemplateBase != "swarm" {
+		t.Errorf("expected portainer in swarm-templates, got base '%s' (%v)", config.TemplateBase, err)
+	}
+	if templateFolderPath(repo, config) != filepath.Join(repo, "swarm-templates", "portainer") || catalogTemplateId(config) != "swarm*portainer" {
+		t.Errorf("unexpected path %s and id %s", templateFolderPath(repo, config), catalogTemplateId(config))
+	}
+
+	config = &model.CatalogUpgrade{TemplateFolderName: "web"}
+	if err := locateTemplate(repo, config); err != nil || config.TemplateBase != "" || catalogTemplateId(config) != "web" {
+		t.Errorf("expected web in templates, got base '%s' (%v)", config.TemplateBase, err)
+	}
+
+	if err := locateTemplate(repo, &model.CatalogUpgrade{TemplateFolderName: "dns"}); err == nil {
+		t.Error("expected error for a template in several bases")
+	}
+	config = &model.CatalogUpgrade{TemplateFolderName: "infra*dns"}
+	if err := locateTemplate(repo, config); err != nil || config.TemplateFolderName != "dns" || !config.TemplateIsSystem {
+		t.Errorf("expected infra template dns, got %+v (%v)", config, err)
+	}
+}
+
+func TestGenerateNewChartVersion(t *testing.T) {
+	repo, err := ioutil.TempDir("", "charts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(repo)
+	chartPath := filepath.Join(repo, "charts", "web")
+	for _, version := range []string{"0.9.0", "0.10.0"} {
+		if err := os.MkdirAll(filepath.Join(chartPath, version, "templates"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(chartPath, version, "Chart.yaml"), []byte("name: web\nversion: "+version+"\nappVersion: 1.0\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(chartPath, version, "templates", "deployment.yaml"), []byte("kind: Deployment\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := &model.CatalogUpgrade{
+		TemplateFolderName: "web",
+		Layout:             LayoutHelm,
+		FromPrevious:       true,
+		Variables:          map[string]string{"appVersion": "1.1"},
+	}
+	version, err := generateNewChartVersion(chartPath, config)
+	if err != nil || version != "0.10.1" {
+		t.Fatalf("expected version 0.10.1, got %s (%v)", version, err)
+	}
+	chart, _ := ioutil.ReadFile(filepath.Join(chartPath, version, "Chart.yaml"))
+	if string(chart) != "name: web\nversion: \"0.10.1\"\nappVersion: \"1.1\"\n" {
+		t.Errorf("unexpected Chart.yaml:\n%s", chart)
+	}
+	if _, err := os.Stat(filepath.Join(chartPath, version, "templates", "deployment.yaml")); err != nil {
+		t.Error("chart templates were not copied")
+	}
+
+	config.Variables = map[string]string{"version": "0.9.0"}
+	if _, err := generateNewChartVersion(chartPath, config); err == nil {
+		t.Error("expected error for an existing version")
+	}
+}
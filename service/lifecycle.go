@@ -0,0 +1,305 @@
+package service
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+	"github.com/rancher/rancher-upgrader/model"
+	yaml "gopkg.in/yaml.v2"
+)
+
+//TemplateInfo describes a template folder of the catalog repo.
+type TemplateInfo struct {
+	Folder   string
+	Base     string
+	Name     string
+	Versions []VersionInfo
+}
+
+//Id is the template as it appears in catalog ids, base*template for templates with a base.
+func (t *TemplateInfo) Id() string {
+	if t.Base != "" {
+		return t.Base + "*" + t.Folder
+	}
+	return t.Folder
+}
+
+//VersionInfo describes a numbered version folder of a template.
+type VersionInfo struct {
+	Folder                int
+	Version               string
+	Hidden                bool
+	MaximumRancherVersion string
+}
+
+//ListTemplates lists the templates of the catalog repo with their versions.
+func ListTemplates(config *model.CatalogUpgrade) ([]TemplateInfo, error) {
+	var templates []TemplateInfo
+	err := withCatalogRepo(config, func(repoPath string) error {
+		bases, err := catalogBases(repoPath)
+		if err != nil {
+			return err
+		}
+		for _, base := range bases {
+			files, err := ioutil.ReadDir(filepath.Join(repoPath, baseFolder(base)))
+			if err != nil {
+				return err
+			}
+			for _, f := range files {
+				if !f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+					continue
+				}
+				info, err := readTemplateInfo(filepath.Join(repoPath, baseFolder(base), f.Name()), base)
+				if err != nil {
+					return err
+				}
+				templates = append(templates, *info)
+			}
+		}
+		return nil
+	})
+	return templates, err
+}
+
+//ShowTemplate describes config.TemplateFolderName.
+func ShowTemplate(config *model.CatalogUpgrade) (*TemplateInfo, error) {
+	var info *TemplateInfo
+	err := withCatalogRepo(config, func(repoPath string) error {
+		if err := locateTemplate(repoPath, config); err != nil {
+			return err
+		}
+		var err error
+		info, err = readTemplateInfo(templateFolderPath(repoPath, config), templateBase(config))
+		return err
+	})
+	return info, err
+}
+
+//ShowTemplateVersion returns the files of a version of config.TemplateFolderName, given as
+//its folder number or catalog version.
+func ShowTemplateVersion(config *model.CatalogUpgrade, version string) (map[string]string, error) {
+	files := map[string]string{}
+	err := withCatalogRepo(config, func(repoPath string) error {
+		if err := locateTemplate(repoPath, config); err != nil {
+			return err
+		}
+		templatePath := templateFolderPath(repoPath, config)
+		info, err := readTemplateInfo(templatePath, templateBase(config))
+		if err != nil {
+			return err
+		}
+		folder, err := info.findVersion(version)
+		if err != nil {
+			return err
+		}
+		versionPath := filepath.Join(templatePath, strconv.Itoa(folder))
+		entries, err := ioutil.ReadDir(versionPath)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if e.IsDir() {
+				continue
+			}
+			content, err := ioutil.ReadFile(filepath.Join(versionPath, e.Name()))
+			if err != nil {
+				return err
+			}
+			files[e.Name()] = string(content)
+		}
+		return nil
+	})
+	return files, err
+}
+
+//DeprecateVersions hides the given version folders of config.TemplateFolderName and/or caps the
+//Rancher version they can be deployed on, then publishes the change.
+func DeprecateVersions(config *model.CatalogUpgrade, folders []int, hide bool, maximumRancherVersion string) error {
+	if len(folders) == 0 {
+		return errors.New("no versions to deprecate")
+	}
+	if !hide && maximumRancherVersion == "" {
+		return errors.New("deprecating needs hiding versions or a maximum rancher version")
+	}
+	sort.Ints(folders)
+	return withCatalogRepo(config, func(repoPath string) error {
+		if err := locateTemplate(repoPath, config); err != nil {
+			return err
+		}
+		templatePath := templateFolderPath(repoPath, config)
+		for _, folder := range folders {
+			file := filepath.Join(templatePath, strconv.Itoa(folder), "rancher-compose.yml")
+			content, err := ioutil.ReadFile(file)
+			if err != nil {
+				return errors.Wrapf(err, "read version %d failed", folder)
+			}
+			rancherCompose := string(content)
+			if hide {
+				if rancherCompose, err = setCatalogFieldRaw(rancherCompose, "hidden", "true"); err != nil {
+					return errors.Wrapf(err, "version %d", folder)
+				}
+			}
+			if maximumRancherVersion != "" {
+				if rancherCompose, err = setCatalogField(rancherCompose, "maximum_rancher_version", maximumRancherVersion); err != nil {
+					return errors.Wrapf(err, "version %d", folder)
+				}
+			}
+			if err := ioutil.WriteFile(file, []byte(rancherCompose), 0644); err != nil {
+				return err
+			}
+		}
+		title := fmt.Sprintf("%s: deprecate versions %s", config.TemplateFolderName, joinInts(folders))
+		return publishChange(repoPath, title, fmt.Sprintf("rancher-upgrader/%s-deprecate-%d", config.TemplateFolderName, folders[len(folders)-1]), config)
+	})
+}
+
+//PruneVersions removes all but the keep newest version folders of config.TemplateFolderName,
+//sparing every version a stack in the environments of apis is deployed from, then
+//publishes the change. The removed version folders are returned.
+func PruneVersions(config *model.CatalogUpgrade, apis []RancherAPI, keep int) ([]int, error) {
+	if keep < 1 {
+		return nil, errors.New("at least the latest version has to be kept")
+	}
+	var pruned []int
+	err := withCatalogRepo(config, func(repoPath string) error {
+		if err := locateTemplate(repoPath, config); err != nil {
+			return err
+		}
+		inUse := map[int]bool{}
+		for _, api := range apis {
+			used, err := versionsInUse(api, config)
+			if err != nil {
+				return err
+			}
+			for folder := range used {
+				inUse[folder] = true
+			}
+		}
+
+		templatePath := templateFolderPath(repoPath, config)
+		info, err := readTemplateInfo(templatePath, templateBase(config))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < len(info.Versions)-keep; i++ {
+			folder := info.Versions[i].Folder
+			if inUse[folder] {
+				logrus.Infof("keeping version %d of %s, stacks are deployed from it", folder, config.TemplateFolderName)
+				continue
+			}
+			if err := os.RemoveAll(filepath.Join(templatePath, strconv.Itoa(folder))); err != nil {
+				return err
+			}
+			pruned = append(pruned, folder)
+		}
+		if len(pruned) == 0 {
+			logrus.Infof("no version of %s to prune", config.TemplateFolderName)
+			return nil
+		}
+		title := fmt.Sprintf("%s: prune versions %s", config.TemplateFolderName, joinInts(pruned))
+		return publishChange(repoPath, title, fmt.Sprintf("rancher-upgrader/%s-prune-%d", config.TemplateFolderName, pruned[len(pruned)-1]), config)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pruned, nil
+}
+
+//versionsInUse returns the version folders of config.TemplateFolderName stacks in the
+//environment are deployed from. Without config.CatalogName stacks of any catalog count.
+func versionsInUse(api RancherAPI, config *model.CatalogUpgrade) (map[int]bool, error) {
+	base := templateBase(config)
+	inUse := map[int]bool{}
+	stacks, err := api.ListStacks()
+	if err != nil {
+		return nil, errors.Wrap(err, "list stacks failed")
+	}
+	for _, stack := range stacks {
+		c, t, b, v, ok := TemplateURLPath(strings.TrimPrefix(stack.ExternalId, "catalog://"))
+		if !ok || t != config.TemplateFolderName || b != base || (config.CatalogName != "" && c != config.CatalogName) {
+			continue
+		}
+		if folder, err := strconv.Atoi(v); err == nil {
+			inUse[folder] = true
+		}
+	}
+	return inUse, nil
+}
+
+//readTemplateInfo reads the template at templatePath, its versions sorted by folder.
+func readTemplateInfo(templatePath, base string) (*TemplateInfo, error) {
+	if _, err := os.Stat(templatePath); err != nil {
+		return nil, fmt.Errorf("template %s not found", filepath.Base(templatePath))
+	}
+	info := &TemplateInfo{
+		Folder: filepath.Base(templatePath),
+		Base:   base,
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(templatePath, "config.yml")); err == nil {
+		templateConfig := struct {
+			Name string `yaml:"name"`
+		}{}
+		yaml.Unmarshal(content, &templateConfig)
+		info.Name = templateConfig.Name
+	}
+
+	files, err := ioutil.ReadDir(templatePath)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		folder, err := strconv.Atoi(f.Name())
+		if err != nil || !f.IsDir() {
+			continue
+		}
+		version := VersionInfo{Folder: folder}
+		if content, err := ioutil.ReadFile(filepath.Join(templatePath, f.Name(), "rancher-compose.yml")); err == nil {
+			spec := &catalogSpec{}
+			yaml.Unmarshal(content, spec)
+			version.Version = spec.Catalog.Version
+			version.Hidden = spec.Catalog.Hidden
+			version.MaximumRancherVersion = spec.Catalog.MaximumRancherVersion
+		}
+		info.Versions = append(info.Versions, version)
+	}
+	sort.Sort(byFolder(info.Versions))
+	return info, nil
+}
+
+//findVersion returns the folder of a version given as folder number or catalog version.
+func (t *TemplateInfo) findVersion(version string) (int, error) {
+	if folder, err := strconv.Atoi(version); err == nil {
+		for _, v := range t.Versions {
+			if v.Folder == folder {
+				return folder, nil
+			}
+		}
+	}
+	for _, v := range t.Versions {
+		if v.Version == version {
+			return v.Folder, nil
+		}
+	}
+	return -1, fmt.Errorf("template %s has no version %s", t.Folder, version)
+}
+
+type byFolder []VersionInfo
+
+func (v byFolder) Len() int           { return len(v) }
+func (v byFolder) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v byFolder) Less(i, j int) bool { return v[i].Folder < v[j].Folder }
+
+func joinInts(ints []int) string {
+	s := make([]string, len(ints))
+	for i, n := range ints {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, ", ")
+}
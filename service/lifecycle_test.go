@@ -0,0 +1,52 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadTemplateInfo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.yml"), []byte("name: Web\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for folder, version := range map[string]string{"2": "1.2.4", "10": "1.3.0", "0": "1.2.3"} {
+		if err := os.Mkdir(filepath.Join(dir, folder), 0755); err != nil {
+			t.Fatal(err)
+		}
+		rancherCompose := strings.Replace(testRancherCompose, "1.2.3", version, 1)
+		if folder == "0" {
+			rancherCompose, _ = setCatalogFieldRaw(rancherCompose, "hidden", "true")
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, folder, "rancher-compose.yml"), []byte(rancherCompose), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	info, err := readTemplateInfo(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "Web" || len(info.Versions) != 3 {
+		t.Fatalf("unexpected template %+v", info)
+	}
+	if info.Versions[0].Folder != 0 || !info.Versions[0].Hidden || info.Versions[2].Folder != 10 || info.Versions[2].Version != "1.3.0" {
+		t.Errorf("unexpected versions %+v", info.Versions)
+	}
+	if folder, err := info.findVersion("1.2.4"); err != nil || folder != 2 {
+		t.Errorf("expected folder 2 for version 1.2.4, got %d (%v)", folder, err)
+	}
+	if folder, err := info.findVersion("10"); err != nil || folder != 10 {
+		t.Errorf("expected folder 10, got %d (%v)", folder, err)
+	}
+	if _, err := info.findVersion("9.9"); err == nil {
+		t.Error("expected error for unknown version")
+	}
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rancher/go-rancher/catalog"
+)
+
+//QuestionDiff describes how the questions of two template versions differ.
+type QuestionDiff struct {
+	Added   []string
+	Removed []string
+}
+
+//Empty reports whether the template versions ask the same questions.
+func (d *QuestionDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0
+}
+
+func (d *QuestionDiff) String() string {
+	return fmt.Sprintf("added [%s], removed [%s]", strings.Join(d.Added, ","), strings.Join(d.Removed, ","))
+}
+
+//DiffQuestions compares the questions of the current and the target template version.
+func DiffQuestions(oldQuestions, newQuestions []catalog.Question) *QuestionDiff {
+	diff := &QuestionDiff{}
+	oldVars := questionVariables(oldQuestions)
+	newVars := questionVariables(newQuestions)
+	for v := range newVars {
+		if !oldVars[v] {
+			diff.Added = append(diff.Added, v)
+		}
+	}
+	for v := range oldVars {
+		if !newVars[v] {
+			diff.Removed = append(diff.Removed, v)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	return diff
+}
+
+//MergeAnswers builds the answers for the target template version. Supplied answers win over
+//previous ones, new questions fall back to their defaults and every answer is validated.
+func MergeAnswers(questions []catalog.Question, previous, supplied map[string]interface{}) (map[string]interface{}, error) {
+	answers := map[string]interface{}{}
+	var missing []string
+	for _, q := range questions {
+		value, ok := lookupAnswer(q.Variable, supplied)
+		if !ok {
+			value, ok = lookupAnswer(q.Variable, previous)
+		}
+		if !ok && q.Default != "" {
+			value, ok = q.Default, true
+		}
+		if !ok || value == "" {
+			if q.Required {
+				missing = append(missing, q.Variable)
+			}
+			continue
+		}
+		if err := validateAnswer(q, value); err != nil {
+			return nil, err
+		}
+		answers[q.Variable] = value
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing answers for required questions: %s", strings.Join(missing, ","))
+	}
+
+	//answers without a matching question are still passed through, compose files may reference them.
+	known := questionVariables(questions)
+	for k, v := range supplied {
+		if !known[k] {
+			log.Debugf("answer '%s' does not match any question", k)
+			answers[k] = fmt.Sprint(v)
+		}
+	}
+	return answers, nil
+}
+
+func lookupAnswer(variable string, answers map[string]interface{}) (string, bool) {
+	if answers == nil {
+		return "", false
+	}
+	v, ok := answers[variable]
+	if !ok || v == nil {
+		return "", false
+	}
+	return fmt.Sprint(v), true
+}
+
+func questionVariables(questions []catalog.Question) map[string]bool {
+	vars := map[string]bool{}
+	for _, q := range questions {
+		vars[q.Variable] = true
+	}
+	return vars
+}
+
+func validateAnswer(q catalog.Question, value string) error {
+	switch q.Type {
+	case "int":
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("answer for '%s' must be an integer, got '%s'", q.Variable, value)
+		}
+		if q.Min != 0 && i < q.Min {
+			return fmt.Errorf("answer for '%s' must be at least %d", q.Variable, q.Min)
+		}
+		if q.Max != 0 && i > q.Max {
+			return fmt.Errorf("answer for '%s' must be at most %d", q.Variable, q.Max)
+		}
+		return nil
+	case "float":
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("answer for '%s' must be a number, got '%s'", q.Variable, value)
+		}
+		return nil
+	case "boolean":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("answer for '%s' must be true or false, got '%s'", q.Variable, value)
+		}
+		return nil
+	case "enum":
+		for _, o := range q.Options {
+			if o == value {
+				return nil
+			}
+		}
+		return fmt.Errorf("answer for '%s' must be one of [%s], got '%s'", q.Variable, strings.Join(q.Options, ","), value)
+	case "service":
+		//services are referenced as <stack>/<service> or just <service> in the same stack.
+		if parts := strings.Split(value, "/"); len(parts) > 2 || parts[len(parts)-1] == "" {
+			return fmt.Errorf("answer for '%s' must be a service reference like 'stack/service', got '%s'", q.Variable, value)
+		}
+		return nil
+	case "certificate":
+		if strings.TrimSpace(value) == "" {
+			return fmt.Errorf("answer for '%s' must name a certificate", q.Variable)
+		}
+		return nil
+	case "", "string", "multiline", "password":
+		return validateString(q, value)
+	default:
+		return fmt.Errorf("question '%s' has unsupported type '%s'", q.Variable, q.Type)
+	}
+}
+
+func validateString(q catalog.Question, value string) error {
+	if q.MinLength != 0 && int64(len(value)) < q.MinLength {
+		return fmt.Errorf("answer for '%s' must be at least %d characters", q.Variable, q.MinLength)
+	}
+	if q.MaxLength != 0 && int64(len(value)) > q.MaxLength {
+		return fmt.Errorf("answer for '%s' must be at most %d characters", q.Variable, q.MaxLength)
+	}
+	if q.ValidChars != "" {
+		for _, c := range value {
+			if !strings.ContainsRune(q.ValidChars, c) {
+				return fmt.Errorf("answer for '%s' contains invalid character '%c'", q.Variable, c)
+			}
+		}
+	}
+	if q.InvalidChars != "" && strings.ContainsAny(value, q.InvalidChars) {
+		return fmt.Errorf("answer for '%s' contains one of the invalid characters '%s'", q.Variable, q.InvalidChars)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/rancher/go-rancher/catalog"
+)
+
+func TestMergeAnswers(t *testing.T) {
+	questions := []catalog.Question{
+		{Variable: "REPLICAS", Type: "int", Default: "1"},
+		{Variable: "DEBUG", Type: "boolean", Default: "false"},
+		{Variable: "DB_PASSWORD", Type: "password", Required: true},
+		{Variable: "MODE", Type: "enum", Options: []string{"a", "b"}, Default: "a"},
+	}
+	previous := map[string]interface{}{"REPLICAS": "3", "DB_PASSWORD": "secret", "OLD": "x"}
+	supplied := map[string]interface{}{"DEBUG": "true"}
+
+	answers, err := MergeAnswers(questions, previous, supplied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"REPLICAS": "3", "DEBUG": "true", "DB_PASSWORD": "secret", "MODE": "a"}
+	for k, v := range expected {
+		if answers[k] != v {
+			t.Errorf("answer %s: expected %s, got %v", k, v, answers[k])
+		}
+	}
+	if _, ok := answers["OLD"]; ok {
+		t.Error("answer for removed question should be dropped")
+	}
+
+	if _, err := MergeAnswers(questions, nil, supplied); err == nil {
+		t.Error("expected error for missing required answer")
+	}
+	if _, err := MergeAnswers(questions, previous, map[string]interface{}{"MODE": "c"}); err == nil {
+		t.Error("expected error for invalid enum answer")
+	}
+}
+
+func TestDiffQuestions(t *testing.T) {
+	diff := DiffQuestions(
+		[]catalog.Question{{Variable: "A"}, {Variable: "B"}},
+		[]catalog.Question{{Variable: "B"}, {Variable: "C"}},
+	)
+	if len(diff.Added) != 1 || diff.Added[0] != "C" {
+		t.Errorf("unexpected added questions: %v", diff.Added)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0] != "A" {
+		t.Errorf("unexpected removed questions: %v", diff.Removed)
+	}
+}
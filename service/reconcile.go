@@ -0,0 +1,255 @@
+package service
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+	"github.com/rancher/go-rancher/v2"
+	"github.com/rancher/rancher-upgrader/model"
+	yaml "gopkg.in/yaml.v2"
+)
+
+//States of a stack compared with its desired state, and after reconciling it.
+const (
+	StackInSync    = "in-sync"
+	StackDrifted   = "drifted"
+	StackMissing   = "missing"
+	StackUnmanaged = "unmanaged"
+	StackUpgraded  = "upgraded"
+	StackCreated   = "created"
+	StackFailed    = "failed"
+)
+
+//StackDrift is how a live stack compares with its desired state.
+type StackDrift struct {
+	Stack       string
+	State       string
+	Differences []string
+	Err         error
+}
+
+//LoadDesiredStacks reads the stacks declared in dir, a folder per stack named like it. A stack
+//folder has docker-compose.yml and rancher-compose.yml, or a stack.yml giving the catalog
+//template version as externalId, or both. The answers of stack.yml are applied either way.
+func LoadDesiredStacks(dir string) ([]model.DesiredStack, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var stacks []model.DesiredStack
+	for _, f := range files {
+		if !f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		stackDir := filepath.Join(dir, f.Name())
+		stack := model.DesiredStack{}
+		content, err := ioutil.ReadFile(filepath.Join(stackDir, "stack.yml"))
+		if err == nil {
+			if err := yaml.Unmarshal(content, &stack); err != nil {
+				return nil, errors.Wrapf(err, "parse %s failed", filepath.Join(stackDir, "stack.yml"))
+			}
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+		stack.Name = f.Name()
+		if stack.DockerCompose, err = readStackFile(stackDir, "docker-compose.yml"); err != nil {
+			return nil, err
+		}
+		if stack.RancherCompose, err = readStackFile(stackDir, "rancher-compose.yml"); err != nil {
+			return nil, err
+		}
+		if stack.DockerCompose == "" && stack.ExternalId == "" {
+			return nil, fmt.Errorf("stack %s has neither a docker-compose.yml nor an externalId in stack.yml", stack.Name)
+		}
+		stacks = append(stacks, stack)
+	}
+	return stacks, nil
+}
+
+func readStackFile(stackDir, name string) (string, error) {
+	content, err := ioutil.ReadFile(filepath.Join(stackDir, name))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return string(content), err
+}
+
+//ReconcileStacks compares the stacks of the environment with the desired ones. With apply set
+//the drifted stacks are upgraded and the missing ones created through UpgradeStack, config
+//gives the secrets and wait timeout used. Live stacks not declared are reported as unmanaged
+//and left alone, so are system stacks. A stack failing to compare or to reconcile is reported
+//as failed and the others go on.
+func ReconcileStacks(api RancherAPI, desired []model.DesiredStack, config *model.StackUpgrade, apply bool) ([]StackDrift, error) {
+	live, err := api.ListStacks()
+	if err != nil {
+		log.Errorf("Error %v in listing stacks", err)
+		return nil, err
+	}
+	byName := map[string]*client.Stack{}
+	for i := range live {
+		byName[live[i].Name] = &live[i]
+	}
+
+	var drifts []StackDrift
+	declared := map[string]bool{}
+	failed := 0
+	for _, stack := range desired {
+		declared[stack.Name] = true
+		drift, err := stackDrift(api, stack, byName[stack.Name], config)
+		if err != nil {
+			drift.State, drift.Err = StackFailed, err
+			failed++
+		} else if apply && drift.State != StackInSync {
+			stackConfig := *config
+			stackConfig.StackName = stack.Name
+			stackConfig.DockerCompose = stack.DockerCompose
+			stackConfig.RancherCompose = stack.RancherCompose
+			stackConfig.ExternalId = stack.ExternalId
+			stackConfig.Environment = stack.Answers
+			stackConfig.InstallIfMissing = true
+			if drift.Err = UpgradeStack(api, &stackConfig); drift.Err != nil {
+				drift.State = StackFailed
+				failed++
+			} else if drift.State == StackMissing {
+				drift.State = StackCreated
+			} else {
+				drift.State = StackUpgraded
+			}
+		}
+		drifts = append(drifts, drift)
+	}
+	for _, stack := range live {
+		if !declared[stack.Name] && !stack.System {
+			drifts = append(drifts, StackDrift{Stack: stack.Name, State: StackUnmanaged})
+		}
+	}
+	if failed > 0 {
+		return drifts, fmt.Errorf("%d stacks failed to reconcile", failed)
+	}
+	return drifts, nil
+}
+
+//stackDrift compares a live stack, nil when it doesn't exist, with its desired state.
+func stackDrift(api RancherAPI, desired model.DesiredStack, live *client.Stack, config *model.StackUpgrade) (StackDrift, error) {
+	drift := StackDrift{Stack: desired.Name, State: StackInSync}
+	if live == nil {
+		drift.State = StackMissing
+		return drift, nil
+	}
+	answers := desired.Answers
+	if config.Secrets != nil {
+		var err error
+		if answers, err = config.Secrets.ResolveAll(desired.Answers); err != nil {
+			return drift, err
+		}
+	}
+
+	var differences []string
+	if live.ExternalId != desired.ExternalId {
+		differences = append(differences, fmt.Sprintf("externalId: want %q, got %q", desired.ExternalId, live.ExternalId))
+	}
+	for _, k := range sortedKeys(answers) {
+		want := fmt.Sprint(answers[k])
+		got, ok := live.Environment[k]
+		//answers resolved from a secret reference are compared without showing either value,
+		//the live one may be a secret masking doesn't know, e.g. before a rotation.
+		secret := want != fmt.Sprint(desired.Answers[k])
+		switch {
+		case !ok && secret:
+			differences = append(differences, fmt.Sprintf("answer %s: secret, not set", k))
+		case !ok:
+			differences = append(differences, fmt.Sprintf("answer %s: want %q, not set", k, want))
+		case fmt.Sprint(got) == want:
+		case secret:
+			differences = append(differences, fmt.Sprintf("answer %s: secret differs", k))
+		default:
+			differences = append(differences, fmt.Sprintf("answer %s: want %q, got %q", k, want, fmt.Sprint(got)))
+		}
+	}
+	if desired.DockerCompose != "" || desired.RancherCompose != "" {
+		exported, err := api.ExportStackConfig(live)
+		if err != nil {
+			return drift, errors.Wrapf(err, "export config of stack %s failed", desired.Name)
+		}
+		differences = append(differences, composeDiff("docker-compose.yml", desired.DockerCompose, exported.DockerComposeConfig)...)
+		differences = append(differences, composeDiff("rancher-compose.yml", desired.RancherCompose, exported.RancherComposeConfig)...)
+	}
+
+	for _, d := range differences {
+		drift.Differences = append(drift.Differences, config.Secrets.MaskString(d))
+	}
+	if len(drift.Differences) > 0 {
+		drift.State = StackDrifted
+	}
+	return drift, nil
+}
+
+//composeDiff lists what the desired compose file declares that the live one lacks or has
+//different. Keys Rancher adds to the exported config don't count as drift, and values using
+//${...} are skipped since the live config has them interpolated.
+func composeDiff(file, desired, live string) []string {
+	if desired == "" {
+		return nil
+	}
+	var desiredValue, liveValue interface{}
+	if err := yaml.Unmarshal([]byte(desired), &desiredValue); err != nil {
+		return []string{fmt.Sprintf("%s: %v", file, err)}
+	}
+	yaml.Unmarshal([]byte(live), &liveValue)
+	var differences []string
+	diffValue(file, desiredValue, liveValue, &differences)
+	return differences
+}
+
+func diffValue(path string, desired, live interface{}, differences *[]string) {
+	switch d := desired.(type) {
+	case map[interface{}]interface{}:
+		l, _ := live.(map[interface{}]interface{})
+		keys := make([]string, 0, len(d))
+		byKey := map[string]interface{}{}
+		for k := range d {
+			keys = append(keys, fmt.Sprint(k))
+			byKey[fmt.Sprint(k)] = k
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			lv, ok := l[byKey[key]]
+			if !ok {
+				*differences = append(*differences, fmt.Sprintf("%s: %s is missing", path, key))
+				continue
+			}
+			diffValue(path+": "+key, d[byKey[key]], lv, differences)
+		}
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok || len(l) != len(d) {
+			*differences = append(*differences, fmt.Sprintf("%s: want %v, got %v", path, d, live))
+			return
+		}
+		for i := range d {
+			diffValue(fmt.Sprintf("%s[%d]", path, i), d[i], l[i], differences)
+		}
+	default:
+		if s, ok := desired.(string); ok && strings.Contains(s, "${") {
+			return
+		}
+		if fmt.Sprint(desired) != fmt.Sprint(live) {
+			*differences = append(*differences, fmt.Sprintf("%s: want %v, got %v", path, desired, live))
+		}
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rancher/go-rancher/v2"
+	"github.com/rancher/rancher-upgrader/answers"
+	"github.com/rancher/rancher-upgrader/model"
+)
+
+func TestReconcileStacks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "desired")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(file, content string) {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, file)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, file), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("web/stack.yml", "externalId: catalog://lib:web:2\nanswers:\n  REPLICAS: 3\n")
+	write("db/docker-compose.yml", "version: '2'\nservices:\n  db:\n    image: postgres:9.6\n    environment:\n      PASSWORD: ${PASSWORD}\n")
+	write("cache/docker-compose.yml", "version: '2'\nservices:\n  redis:\n    image: redis:4\n")
+	write(".git/config", "")
+
+	desired, err := LoadDesiredStacks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &fakeAPI{
+		stacks: []client.Stack{
+			{Resource: client.Resource{Id: "1st1"}, Name: "web", ExternalId: "catalog://lib:web:1", Environment: map[string]interface{}{"REPLICAS": "3"}},
+			{Resource: client.Resource{Id: "1st2"}, Name: "db", DockerCompose: "version: '2'\nservices:\n  db:\n    image: postgres:9.6\n    environment:\n      PASSWORD: s3cret\n    labels:\n      io.rancher.container.pull_image: always\n"},
+			{Resource: client.Resource{Id: "1st3"}, Name: "legacy"},
+			{Resource: client.Resource{Id: "1st4"}, Name: "healthcheck", System: true},
+		},
+		templates: templateFixture(),
+	}
+
+	drifts, err := ReconcileStacks(api, desired, &model.StackUpgrade{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := func() string {
+		var s []string
+		for _, d := range drifts {
+			s = append(s, d.Stack+"="+d.State)
+		}
+		return strings.Join(s, ",")
+	}
+	if expected := "cache=missing,db=in-sync,web=drifted,legacy=unmanaged"; states() != expected {
+		t.Errorf("expected %s, got %s", expected, states())
+	}
+	if d := drifts[2].Differences; len(d) != 1 || !strings.Contains(d[0], "lib:web:1") {
+		t.Errorf("expected the externalId difference of web, got %v", d)
+	}
+	if len(api.calls) != 0 {
+		t.Errorf("a dry run must not change stacks, got calls %v", api.calls)
+	}
+
+	drifts, err = ReconcileStacks(api, desired, &model.StackUpgrade{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "cache=created,db=in-sync,web=upgraded,legacy=unmanaged"; states() != expected {
+		t.Errorf("expected %s, got %s", expected, states())
+	}
+	if drifts, _ = ReconcileStacks(api, desired, &model.StackUpgrade{}, false); states() != "cache=in-sync,db=in-sync,web=in-sync,legacy=unmanaged" {
+		t.Errorf("expected every stack in sync after reconciling, got %s", states())
+	}
+
+	//a stack failing to compare is reported, the others still are.
+	os.Unsetenv("RANCHER_UPGRADER_TEST_UNSET")
+	desired[1].Answers = map[string]interface{}{"PASSWORD": "${env:RANCHER_UPGRADER_TEST_UNSET}"}
+	drifts, err = ReconcileStacks(api, desired, &model.StackUpgrade{Secrets: answers.NewSecrets()}, true)
+	if err == nil {
+		t.Error("expected an error for the failed stack")
+	}
+	if expected := "cache=in-sync,db=failed,web=in-sync,legacy=unmanaged"; states() != expected {
+		t.Errorf("expected %s, got %s", expected, states())
+	}
+	if drifts[1].Err == nil {
+		t.Error("expected the error of the failed stack")
+	}
+
+	//a drifted secret answer shows neither the live nor the desired value.
+	os.Setenv("RANCHER_UPGRADER_TEST_SECRET", "new-s3cret")
+	defer os.Unsetenv("RANCHER_UPGRADER_TEST_SECRET")
+	desired[1].Answers = nil
+	desired[2].Answers["PASSWORD"] = "${env:RANCHER_UPGRADER_TEST_SECRET}"
+	api.stacks[0].Environment["PASSWORD"] = "old-s3cret"
+	drifts, err = ReconcileStacks(api, desired, &model.StackUpgrade{Secrets: answers.NewSecrets()}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := drifts[2].Differences; len(d) != 1 || d[0] != "answer PASSWORD: secret differs" {
+		t.Errorf("expected the secret answer to differ without values, got %v", d)
+	}
+}
+
+func TestComposeDiff(t *testing.T) {
+	desired := "services:\n  web:\n    image: web:2\n    ports: ['80:80']\n    scale: ${SCALE}\n"
+	live := "services:\n  web:\n    image: web:1\n    ports: ['80:80', '443:443']\n    scale: 2\n    labels: {a: b}\n"
+	expected := []string{
+		"docker-compose.yml: services: web: image: want web:2, got web:1",
+		"docker-compose.yml: services: web: ports: want [80:80], got [80:80 443:443]",
+	}
+	if d := composeDiff("docker-compose.yml", desired, live); strings.Join(d, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(d, "\n"))
+	}
+	if d := composeDiff("docker-compose.yml", "services:\n  db: {}\n", live); len(d) != 1 || !strings.Contains(d[0], "db is missing") {
+		t.Errorf("expected the missing service, got %v", d)
+	}
+}
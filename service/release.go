@@ -0,0 +1,116 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+	"github.com/rancher/go-rancher/v2"
+	"github.com/rancher/rancher-upgrader/model"
+)
+
+var (
+	releasePollInterval    = 5 * time.Second
+	releaseRefreshInterval = 30 * time.Second
+)
+
+//Release publishes a new version of the catalog template, waits for the catalog of the
+//environment to serve it and upgrades the named stacks, or every stack deployed from the
+//template without names, to exactly that version.
+func Release(api RancherAPI, catalogConfig *model.CatalogUpgrade, stackConfig *model.StackUpgrade, stackNames []string, timeout time.Duration) ([]StackResult, error) {
+	if catalogConfig.CatalogName == "" {
+		return nil, errors.New("the name the catalog repo is added to rancher as is required")
+	}
+	if catalogConfig.Forge != "" {
+		return nil, errors.New("release pushes to the catalog branch, a pull request would have to be merged first")
+	}
+	//the stacks can only be upgraded to the version once the catalog branch has it.
+	catalogConfig.PushToBranch = true
+	if catalogConfig.Layout == LayoutHelm {
+		return nil, errors.New("stacks can't be deployed from helm charts")
+	}
+	if stackConfig.Secrets != nil {
+		env, err := stackConfig.Secrets.ResolveAll(stackConfig.Environment)
+		if err != nil {
+			return nil, err
+		}
+		stackConfig.Environment = env
+	}
+
+	if err := LocateTemplate(catalogConfig); err != nil {
+		return nil, err
+	}
+	template := catalogConfig.CatalogName + ":" + catalogTemplateId(catalogConfig)
+	var stacks []client.Stack
+	var err error
+	if len(stackNames) > 0 {
+		stacks, err = namedStacks(api, stackNames)
+	} else {
+		stacks, err = TemplateStacks(api, template)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := PublishTemplateVersion(catalogConfig)
+	if err != nil {
+		return nil, err
+	}
+	externalId := fmt.Sprintf("catalog://%s:%s", template, version)
+
+	if err := waitTemplateVersion(api, externalId, timeout); err != nil {
+		return nil, err
+	}
+	if len(stacks) == 0 {
+		log.Infof("no stack is deployed from template '%s'", template)
+		return nil, nil
+	}
+	return upgradeStacks(api, stackConfig, stacks, externalId)
+}
+
+//namedStacks looks up the stacks by name, all of them have to exist.
+func namedStacks(api RancherAPI, names []string) ([]client.Stack, error) {
+	list, err := api.ListStacks()
+	if err != nil {
+		log.Errorf("Error %v in listing stacks", err)
+		return nil, err
+	}
+	byName := map[string]client.Stack{}
+	for _, stack := range list {
+		byName[stack.Name] = stack
+	}
+	var stacks []client.Stack
+	for _, name := range names {
+		stack, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("stack %s is not found", name)
+		}
+		stacks = append(stacks, stack)
+	}
+	return stacks, nil
+}
+
+//waitTemplateVersion refreshes the catalog until it serves the template version externalId.
+func waitTemplateVersion(api RancherAPI, externalId string, timeout time.Duration) error {
+	log.Infof("waiting for the catalog to serve %s...", externalId)
+	deadline := time.Now().Add(timeout)
+	var lastRefresh time.Time
+	for {
+		if time.Since(lastRefresh) >= releaseRefreshInterval {
+			if err := api.RefreshCatalog(); err != nil {
+				return errors.Wrap(err, "refresh catalog failed")
+			}
+			lastRefresh = time.Now()
+		}
+		_, err := api.TemplateVersion(externalId)
+		if err == nil {
+			return nil
+		}
+		log.Debugf("template version is not served yet: %v", err)
+		if time.Now().After(deadline) {
+			return fmt.Errorf("catalog did not serve %s within %s: %v", externalId, timeout, err)
+		}
+		time.Sleep(releasePollInterval)
+	}
+}
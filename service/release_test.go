@@ -0,0 +1,54 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWaitTemplateVersion(t *testing.T) {
+	refreshes, polls := 0, 0
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1-catalog/templates" && r.URL.Query().Get("action") == "refresh":
+			refreshes++
+			w.WriteHeader(http.StatusNoContent)
+		case strings.HasPrefix(r.URL.Path, "/v1-catalog/templates/"):
+			polls++
+			if r.URL.Path != "/v1-catalog/templates/lib:web:3" || polls < 3 {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(`{"id":"lib:web:3","version":"1.2.4"}`))
+		default:
+			w.Header().Set("X-Api-User-Id", "1a5")
+			w.Header().Set("X-API-Schemas", server.URL+r.URL.Path)
+			w.Write([]byte(`{"type":"collection","data":[]}`))
+		}
+	}))
+	defer server.Close()
+
+	defer func(poll, refresh time.Duration) {
+		releasePollInterval, releaseRefreshInterval = poll, refresh
+	}(releasePollInterval, releaseRefreshInterval)
+	releasePollInterval, releaseRefreshInterval = time.Millisecond, 2*time.Millisecond
+
+	cattleUrl := server.URL + "/v2-beta/projects/1a5"
+	api, err := NewRancherAPI(cattleUrl, "", "", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := waitTemplateVersion(api, "catalog://lib:web:3", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if polls != 3 || refreshes < 1 {
+		t.Errorf("expected 3 polls and a refresh, got %d polls and %d refreshes", polls, refreshes)
+	}
+
+	if err := waitTemplateVersion(api, "catalog://lib:web:4", 10*time.Millisecond); err == nil {
+		t.Error("expected timeout for a version the catalog never serves")
+	}
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+	"github.com/rancher/rancher-upgrader/model"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const defaultTemplateVersion = "0.1.0"
+
+//defaultIcon is used for new templates without an icon of their own.
+const defaultIcon = `<svg xmlns="http://www.w3.org/2000/svg" width="128" height="128" viewBox="0 0 128 128">
+  <rect x="8" y="8" width="112" height="112" rx="16" fill="#3d98d3"/>
+  <path d="M40 48l24-14 24 14v32l-24 14-24-14z" fill="none" stroke="#fff" stroke-width="6" stroke-linejoin="round"/>
+</svg>
+`
+
+//InitTemplate creates config.TemplateFolderName in the catalog repo with its config.yml, an
+//icon, a README and version 0 made of the compose files of config, then publishes it. A
+//.catalog section with the name and version of the template is added to the rancher-compose
+//file when it misses one.
+func InitTemplate(config *model.CatalogUpgrade, templateConfig *model.TemplateConfig, iconFile string) error {
+	if config.Layout == LayoutHelm {
+		return errors.New("new helm charts are added with the helm layout of the catalog command")
+	}
+	if config.DockerCompose == "" {
+		return errors.New("a docker-compose file is required for the first version")
+	}
+	if templateConfig.Name == "" {
+		templateConfig.Name = config.TemplateFolderName
+	}
+	var icon []byte
+	iconName := "catalogIcon-" + config.TemplateFolderName + ".svg"
+	if iconFile != "" {
+		var err error
+		if icon, err = ioutil.ReadFile(iconFile); err != nil {
+			return errors.Wrap(err, "read icon failed")
+		}
+		iconName = "catalogIcon-" + config.TemplateFolderName + filepath.Ext(iconFile)
+	} else {
+		icon = []byte(defaultIcon)
+	}
+
+	rancherCompose, err := scaffoldRancherCompose(config.RancherCompose, templateConfig.Name)
+	if err != nil {
+		return err
+	}
+	config.RancherCompose = rancherCompose
+	if templateConfig.Version == "" {
+		templateConfig.Version = catalogField(rancherCompose, "version")
+	}
+	if config.Readme == "" {
+		config.Readme = fmt.Sprintf("# %s\n\n%s\n", templateConfig.Name, templateConfig.Description)
+	}
+
+	return withCatalogRepo(config, func(repoPath string) error {
+		if err := locateTemplate(repoPath, config); err != nil {
+			return err
+		}
+		templatePath := templateFolderPath(repoPath, config)
+		if _, err := os.Stat(templatePath); err == nil {
+			return fmt.Errorf("template %s exists already", catalogTemplateId(config))
+		}
+		if err := os.MkdirAll(templatePath, 0755); err != nil {
+			return err
+		}
+
+		content, err := yaml.Marshal(templateConfig)
+		if err != nil {
+			return err
+		}
+		files := map[string][]byte{
+			"config.yml": content,
+			iconName:     icon,
+			"README.md":  []byte(config.Readme),
+		}
+		for name, content := range files {
+			if err := ioutil.WriteFile(filepath.Join(templatePath, name), content, 0644); err != nil {
+				return err
+			}
+		}
+
+		if err := ValidateTemplateVersion(templatePath, -1, config.DockerCompose, config.RancherCompose); err != nil {
+			return err
+		}
+		versionPath := filepath.Join(templatePath, "0")
+		if err := os.Mkdir(versionPath, 0755); err != nil {
+			return err
+		}
+		files = map[string][]byte{
+			"docker-compose.yml":  []byte(config.DockerCompose),
+			"rancher-compose.yml": []byte(config.RancherCompose),
+		}
+		for name, content := range files {
+			if err := ioutil.WriteFile(filepath.Join(versionPath, name), content, 0644); err != nil {
+				return err
+			}
+		}
+
+		logrus.Infof("created template %s", catalogTemplateId(config))
+		title := fmt.Sprintf("%s: add template", config.TemplateFolderName)
+		return publishChange(repoPath, title, fmt.Sprintf("rancher-upgrader/%s-init", config.TemplateFolderName), config)
+	})
+}
+
+//scaffoldRancherCompose makes sure the rancher-compose file has a .catalog section naming the
+//template and its version.
+func scaffoldRancherCompose(rancherCompose, name string) (string, error) {
+	lines := strings.Split(rancherCompose, "\n")
+	if start, _, _ := catalogSection(lines); start < 0 {
+		section := fmt.Sprintf(".catalog:\n  name: %q\n  version: %q\n", name, defaultTemplateVersion)
+		if strings.TrimSpace(rancherCompose) == "" {
+			return section, nil
+		}
+		return section + rancherCompose, nil
+	}
+	var err error
+	if catalogField(rancherCompose, "version") == "" {
+		if rancherCompose, err = setCatalogField(rancherCompose, "version", defaultTemplateVersion); err != nil {
+			return "", err
+		}
+	}
+	if catalogField(rancherCompose, "name") == "" {
+		if rancherCompose, err = setCatalogField(rancherCompose, "name", name); err != nil {
+			return "", err
+		}
+	}
+	return rancherCompose, nil
+}
@@ -0,0 +1,19 @@
+package service
+
+import (
+	"testing"
+)
+
+func TestScaffoldRancherCompose(t *testing.T) {
+	for in, expected := range map[string]string{
+		"":                              ".catalog:\n  name: \"web\"\n  version: \"0.1.0\"\n",
+		"version: '2'\n":                ".catalog:\n  name: \"web\"\n  version: \"0.1.0\"\nversion: '2'\n",
+		".catalog:\n  version: 1.0.0\n": ".catalog:\n  version: 1.0.0\n  name: \"web\"\n",
+		".catalog:\n  name: Web\n":      ".catalog:\n  version: \"0.1.0\"\n  name: Web\n",
+	} {
+		out, err := scaffoldRancherCompose(in, "web")
+		if err != nil || out != expected {
+			t.Errorf("%q: expected %q, got %q (%v)", in, expected, out, err)
+		}
+	}
+}
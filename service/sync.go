@@ -0,0 +1,137 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+	"github.com/rancher/rancher-upgrader/model"
+	yaml "gopkg.in/yaml.v2"
+)
+
+//SourceCommitLabel is the .catalog label of template versions published from a source repo,
+//it records the commit their compose files were taken from.
+const SourceCommitLabel = "io.rancher.upgrader.source.commit"
+
+//PrepareSourceVersion brings the cached checkout of the source branch up to date and fills the
+//compose files of config from its HEAD commit, see sourceVersion. It returns the commit and
+//whether it changes the template, which it doesn't when the latest version of the template was
+//published from the same commit or from one with the same compose files.
+func PrepareSourceVersion(source *model.SourceSync, config *model.CatalogUpgrade) (string, bool, error) {
+	if config.Layout == LayoutHelm {
+		return "", false, errors.New("source repos hold compose files, they can't be published as helm charts")
+	}
+	dockerCompose, rancherCompose, readme, commit, err := readSource(source)
+	if err != nil {
+		return "", false, err
+	}
+	config.FromPrevious = false
+	config.Readme = readme
+	config.SourceBuild = source.GitUrl + "@" + commit
+	if config.DockerCompose, config.RancherCompose, err = sourceVersion(dockerCompose, rancherCompose, commit); err != nil {
+		return "", false, err
+	}
+
+	changed := true
+	err = withCatalogRepo(config, func(repoPath string) error {
+		if err := locateTemplate(repoPath, config); err != nil {
+			return err
+		}
+		templatePath := templateFolderPath(repoPath, config)
+		latest, err := GetLatestVersion(templatePath)
+		if err != nil || latest < 0 {
+			//a missing template is reported when the version is published.
+			return nil
+		}
+		latestPath := filepath.Join(templatePath, strconv.Itoa(latest))
+		latestDocker, _ := ioutil.ReadFile(filepath.Join(latestPath, "docker-compose.yml"))
+		latestRancher, _ := ioutil.ReadFile(filepath.Join(latestPath, "rancher-compose.yml"))
+		latestCommit := catalogLabel(string(latestRancher), SourceCommitLabel)
+		if latestCommit == "" {
+			return nil
+		}
+		if latestCommit == commit {
+			changed = false
+			return nil
+		}
+		//the files of commit as they would have been published from the latest commit.
+		d, r, err := sourceVersion(dockerCompose, rancherCompose, latestCommit)
+		if err == nil && d == string(latestDocker) && r == string(latestRancher) {
+			logrus.Infof("commit %s doesn't change the compose files of version %d", shortCommit(commit), latest)
+			changed = false
+		}
+		return nil
+	})
+	return commit, changed, err
+}
+
+//readSource returns the compose files and readme in the source path of the source branch and
+//its HEAD commit.
+func readSource(source *model.SourceSync) (string, string, string, string, error) {
+	repo := &model.CatalogUpgrade{GitUrl: source.GitUrl, GitBranch: source.GitBranch, CacheRoot: source.CacheRoot}
+	lock, err := lockCache(cachePath(repo), true)
+	if err != nil {
+		return "", "", "", "", err
+	}
+	defer unlockCache(lock)
+
+	repoPath, commit, err := prepareGitRepoPath(repo)
+	if err != nil {
+		return "", "", "", "", errors.Wrap(err, "prepare source repo failed")
+	}
+	dir := filepath.Join(repoPath, source.Path)
+	dockerCompose, err := ioutil.ReadFile(filepath.Join(dir, "docker-compose.yml"))
+	if err != nil {
+		return "", "", "", "", errors.Wrapf(err, "read docker-compose.yml of source commit %s failed", shortCommit(commit))
+	}
+	rancherCompose, err := ioutil.ReadFile(filepath.Join(dir, "rancher-compose.yml"))
+	if err != nil {
+		return "", "", "", "", errors.Wrapf(err, "read rancher-compose.yml of source commit %s failed", shortCommit(commit))
+	}
+	readme, err := ioutil.ReadFile(filepath.Join(dir, "README.md"))
+	if err != nil && !os.IsNotExist(err) {
+		return "", "", "", "", err
+	}
+	return string(dockerCompose), string(rancherCompose), string(readme), commit, nil
+}
+
+//sourceVersion turns the compose files of a source commit into the files of a template version.
+//The catalog version of the source gets the short commit appended, "1.2" becomes "1.2-<commit>",
+//so that every commit makes a distinct version, and the commit is recorded as SourceCommitLabel.
+func sourceVersion(dockerCompose, rancherCompose, commit string) (string, string, error) {
+	version := shortCommit(commit)
+	if v := catalogField(rancherCompose, "version"); v != "" {
+		version = v + "-" + version
+	}
+	rancherCompose, err := setCatalogField(rancherCompose, "version", version)
+	if err != nil {
+		return "", "", err
+	}
+	if rancherCompose, err = setCatalogLabel(rancherCompose, SourceCommitLabel, commit); err != nil {
+		return "", "", err
+	}
+	return dockerCompose, rancherCompose, nil
+}
+
+//catalogLabel returns a label of the .catalog section, "" if the file can't be parsed.
+func catalogLabel(rancherCompose, key string) string {
+	spec := struct {
+		Catalog struct {
+			Labels map[string]string `yaml:"labels"`
+		} `yaml:".catalog"`
+	}{}
+	if err := yaml.Unmarshal([]byte(rancherCompose), &spec); err != nil {
+		return ""
+	}
+	return spec.Catalog.Labels[key]
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 7 {
+		return commit[:7]
+	}
+	return commit
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rancher/rancher-upgrader/model"
+)
+
+func TestSourceVersion(t *testing.T) {
+	commit := "0123456789abcdef0123456789abcdef01234567"
+	_, rancherCompose, err := sourceVersion("web:\n  image: web:1\n", testRancherCompose, commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := catalogField(rancherCompose, "version"); v != "1.2.3-0123456" {
+		t.Errorf("expected version 1.2.3-0123456, got %s", v)
+	}
+	if c := catalogLabel(rancherCompose, SourceCommitLabel); c != commit {
+		t.Errorf("expected the commit label, got %q in\n%s", c, rancherCompose)
+	}
+	dir, err := ioutil.TempDir("", "template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if problems := versionProblems(dir, -1, "web:\n  image: web:1\n", rancherCompose); len(problems) > 0 {
+		t.Errorf("expected a valid version, got %v", problems)
+	}
+
+	labeled := strings.Replace(testRancherCompose, "  questions:", "  labels:\n    team: shop\n  questions:", 1)
+	_, rancherCompose, err = sourceVersion("", labeled, commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(rancherCompose, "  labels:\n    team: shop\n    "+SourceCommitLabel+": \""+commit+"\"\n  questions:") {
+		t.Errorf("expected the label added to the existing ones, got\n%s", rancherCompose)
+	}
+	replaced, err := setCatalogLabel(rancherCompose, SourceCommitLabel, "fedcba9")
+	if err != nil || catalogLabel(replaced, SourceCommitLabel) != "fedcba9" || strings.Count(replaced, SourceCommitLabel) != 1 {
+		t.Errorf("expected the label to be replaced, got %v\n%s", err, replaced)
+	}
+
+	if _, _, err := sourceVersion("", "web:\n  scale: 1\n", commit); err == nil {
+		t.Error("expected a rancher-compose.yml without .catalog to be refused")
+	}
+}
+
+//pushFiles commits files to master of the bare repo remote, creating it first if needed, and
+//returns the commit.
+func pushFiles(t *testing.T, remote string, files map[string]string) string {
+	git := func(dir string, arg ...string) string {
+		args := append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@localhost"}, arg...)
+		output, err := exec.Command("git", args...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %v: %s", strings.Join(arg, " "), err, output)
+		}
+		return strings.TrimSpace(string(output))
+	}
+	if _, err := os.Stat(remote); os.IsNotExist(err) {
+		git(filepath.Dir(remote), "init", "-q", "--bare", remote)
+		git(remote, "symbolic-ref", "HEAD", "refs/heads/master")
+	}
+	work, err := ioutil.TempDir("", "work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(work)
+	git(work, "clone", "-q", remote, ".")
+	for file, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(work, file)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(work, file), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git(work, "add", "-A")
+	git(work, "commit", "-q", "-m", "update")
+	git(work, "push", "-q", "origin", "HEAD:refs/heads/master")
+	return git(work, "rev-parse", "HEAD")
+}
+
+func TestPrepareSourceVersion(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary is needed to set up the repos")
+	}
+	dir, err := ioutil.TempDir("", "sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dockerCompose := "web:\n  image: web:1\n"
+	source := &model.SourceSync{GitUrl: filepath.Join(dir, "source.git"), Path: "deploy", CacheRoot: filepath.Join(dir, "cache")}
+	commit := pushFiles(t, source.GitUrl, map[string]string{
+		"deploy/docker-compose.yml":  dockerCompose,
+		"deploy/rancher-compose.yml": testRancherCompose,
+	})
+	other := "fedcba9876543210fedcba9876543210fedcba98"
+	published := func(c string) string {
+		_, rancherCompose, err := sourceVersion(dockerCompose, testRancherCompose, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rancherCompose
+	}
+
+	for _, test := range []struct {
+		name           string
+		dockerCompose  string
+		rancherCompose string
+		changed        bool
+	}{
+		{"same commit", dockerCompose, published(commit), false},
+		{"other commit with the same files", dockerCompose, published(other), false},
+		{"other commit with other files", "web:\n  image: web:0\n", published(other), true},
+		{"no source commit label", dockerCompose, testRancherCompose, true},
+	} {
+		catalog := filepath.Join(dir, strings.Replace(test.name, " ", "-", -1)+".git")
+		pushFiles(t, catalog, map[string]string{
+			"templates/web/0/docker-compose.yml":  test.dockerCompose,
+			"templates/web/0/rancher-compose.yml": test.rancherCompose,
+		})
+		config := &model.CatalogUpgrade{GitUrl: catalog, CacheRoot: source.CacheRoot, TemplateFolderName: "web"}
+		got, changed, err := PrepareSourceVersion(source, config)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if got != commit || changed != test.changed {
+			t.Errorf("%s: expected commit %s changed %v, got %s %v", test.name, commit, test.changed, got, changed)
+		}
+		if catalogLabel(config.RancherCompose, SourceCommitLabel) != commit {
+			t.Errorf("%s: expected the version to be prepared from the source commit, got\n%s", test.name, config.RancherCompose)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package service
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+	"github.com/rancher/rancher-upgrader/model"
+)
+
+var (
+	regImageLine   = regexp.MustCompile(`^(\s*image:\s*)(['"]?)([^'"\s]+)(['"]?)(\s*(#.*)?)$`)
+	regKeyLine     = regexp.MustCompile(`^(\s*)(- )?([\w.-]+):\s*(.*)$`)
+	regVersionPart = regexp.MustCompile(`(\d+)(\D*)$`)
+)
+
+//renderFromPrevious fills the files of config that were not given from the previous template
+//version, substituting config.Variables: "image" replaces the tag of the images with the same
+//repository, "version" sets the catalog version (bumped automatically otherwise) and any other
+//variable sets the default answer of the question asking for it. The template config.yml is
+//pointed at the new version.
+func renderFromPrevious(templatePath string, previous int, config *model.CatalogUpgrade) error {
+	if previous < 0 {
+		return errors.New("template has no version to start from")
+	}
+	previousPath := filepath.Join(templatePath, strconv.Itoa(previous))
+
+	if config.DockerCompose == "" {
+		content, err := ioutil.ReadFile(filepath.Join(previousPath, "docker-compose.yml"))
+		if err != nil {
+			return errors.Wrap(err, "read previous docker-compose.yml failed")
+		}
+		config.DockerCompose = string(content)
+	}
+	if config.RancherCompose == "" {
+		content, err := ioutil.ReadFile(filepath.Join(previousPath, "rancher-compose.yml"))
+		if err != nil {
+			return errors.Wrap(err, "read previous rancher-compose.yml failed")
+		}
+		config.RancherCompose = string(content)
+	}
+	if config.Readme == "" {
+		if content, err := ioutil.ReadFile(filepath.Join(previousPath, "README.md")); err == nil {
+			config.Readme = string(content)
+		}
+	}
+
+	previousVersion := catalogField(config.RancherCompose, "version")
+	version := config.Variables["version"]
+	if version == "" {
+		var err error
+		if version, err = bumpVersion(previousVersion); err != nil {
+			return err
+		}
+	}
+
+	for k, v := range config.Variables {
+		switch k {
+		case "version":
+		case "image":
+			var replaced bool
+			config.DockerCompose, replaced = replaceImage(config.DockerCompose, v)
+			if !replaced {
+				return fmt.Errorf("no image of repository %s found in docker-compose.yml", imageRepository(v))
+			}
+		default:
+			var err error
+			if config.RancherCompose, err = setQuestionDefault(config.RancherCompose, k, v); err != nil {
+				return err
+			}
+		}
+	}
+
+	var err error
+	if config.RancherCompose, err = setCatalogField(config.RancherCompose, "version", version); err != nil {
+		return err
+	}
+	if previousVersion != "" {
+		if config.RancherCompose, err = setCatalogField(config.RancherCompose, "upgrade_from", previousVersion); err != nil {
+			return err
+		}
+	}
+	logrus.Infof("rendering version %s of %s from version %s", version, filepath.Base(templatePath), previousVersion)
+
+	return updateConfigVersion(filepath.Join(templatePath, "config.yml"), version)
+}
+
+//bumpVersion increments the last number of a version, 1.2.3 becomes 1.2.4 and v2-rc1 v2-rc2.
+func bumpVersion(version string) (string, error) {
+	loc := regVersionPart.FindStringSubmatchIndex(version)
+	if loc == nil {
+		return "", fmt.Errorf("cannot bump version '%s', set one with --set version=...", version)
+	}
+	n, err := strconv.Atoi(version[loc[2]:loc[3]])
+	if err != nil {
+		return "", err
+	}
+	return version[:loc[2]] + strconv.Itoa(n+1) + version[loc[3]:], nil
+}
+
+func imageRepository(image string) string {
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i]
+	}
+	return image
+}
+
+//replaceImage points every image line with the repository of image at image.
+func replaceImage(compose, image string) (string, bool) {
+	repository := imageRepository(image)
+	lines := strings.Split(compose, "\n")
+	replaced := false
+	for i, line := range lines {
+		m := regImageLine.FindStringSubmatch(line)
+		if m == nil || imageRepository(m[3]) != repository {
+			continue
+		}
+		lines[i] = m[1] + m[2] + image + m[4] + m[5]
+		replaced = true
+	}
+	return strings.Join(lines, "\n"), replaced
+}
+
+//catalogSection returns the line range of the .catalog mapping and the indentation of its keys.
+func catalogSection(lines []string) (int, int, string) {
+	start := -1
+	for i, line := range lines {
+		if strings.TrimRight(line, " ") == ".catalog:" {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return -1, -1, ""
+	}
+	end := len(lines)
+	indent := ""
+	for i := start + 1; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		lineIndent := lines[i][:len(lines[i])-len(strings.TrimLeft(lines[i], " "))]
+		if lineIndent == "" {
+			end = i
+			break
+		}
+		if indent == "" {
+			indent = lineIndent
+		}
+	}
+	if indent == "" {
+		indent = "  "
+	}
+	return start, end, indent
+}
+
+//catalogField returns the value of a key directly in the .catalog section.
+func catalogField(rancherCompose, key string) string {
+	lines := strings.Split(rancherCompose, "\n")
+	start, end, indent := catalogSection(lines)
+	for i := start + 1; i >= 1 && i < end; i++ {
+		if m := regKeyLine.FindStringSubmatch(lines[i]); m != nil && m[1] == indent && m[2] == "" && m[3] == key {
+			return unquote(m[4])
+		}
+	}
+	return ""
+}
+
+//setCatalogField sets a key directly in the .catalog section, adding it after version if missing.
+func setCatalogField(rancherCompose, key, value string) (string, error) {
+	return setCatalogFieldRaw(rancherCompose, key, strconv.Quote(value))
+}
+
+//setCatalogFieldRaw is setCatalogField for values that must not be quoted, like booleans.
+func setCatalogFieldRaw(rancherCompose, key, value string) (string, error) {
+	lines := strings.Split(rancherCompose, "\n")
+	start, end, indent := catalogSection(lines)
+	if start < 0 {
+		return "", errors.New("rancher-compose.yml has no .catalog section")
+	}
+	line := fmt.Sprintf("%s%s: %s", indent, key, value)
+	at := start + 1
+	for i := start + 1; i < end; i++ {
+		m := regKeyLine.FindStringSubmatch(lines[i])
+		if m == nil || m[1] != indent || m[2] != "" {
+			continue
+		}
+		if m[3] == key {
+			lines[i] = line
+			return strings.Join(lines, "\n"), nil
+		}
+		if m[3] == "version" {
+			at = i + 1
+		}
+	}
+	return strings.Join(insertLine(lines, at, line), "\n"), nil
+}
+
+//setQuestionDefault sets the default of the question asking for variable.
+func setQuestionDefault(rancherCompose, variable, value string) (string, error) {
+	lines := strings.Split(rancherCompose, "\n")
+	start, end, _ := catalogSection(lines)
+	for i := start + 1; i >= 1 && i < end; i++ {
+		m := regKeyLine.FindStringSubmatch(lines[i])
+		if m == nil || m[2] != "- " || m[3] != "variable" || unquote(m[4]) != variable {
+			continue
+		}
+		//the keys of the question item are indented past the "- ".
+		itemIndent := m[1] + "  "
+		line := fmt.Sprintf("%sdefault: %s", itemIndent, strconv.Quote(value))
+		for j := i + 1; j < end; j++ {
+			k := regKeyLine.FindStringSubmatch(lines[j])
+			if k == nil || k[1] != itemIndent || k[2] != "" {
+				break
+			}
+			if k[3] == "default" {
+				lines[j] = line
+				return strings.Join(lines, "\n"), nil
+			}
+		}
+		return strings.Join(insertLine(lines, i+1, line), "\n"), nil
+	}
+	return "", fmt.Errorf("no question asks for variable '%s'", variable)
+}
+
+//setCatalogLabel sets a label in the labels mapping of the .catalog section, adding the mapping
+//after version if missing.
+func setCatalogLabel(rancherCompose, key, value string) (string, error) {
+	lines := strings.Split(rancherCompose, "\n")
+	start, end, indent := catalogSection(lines)
+	if start < 0 {
+		return "", errors.New("rancher-compose.yml has no .catalog section")
+	}
+	at := start + 1
+	for i := start + 1; i < end; i++ {
+		m := regKeyLine.FindStringSubmatch(lines[i])
+		if m == nil || m[1] != indent || m[2] != "" {
+			continue
+		}
+		if m[3] == "version" {
+			at = i + 1
+		}
+		if m[3] != "labels" {
+			continue
+		}
+		if m[4] != "" {
+			return "", errors.New("rancher-compose.yml: .catalog labels must be a block mapping")
+		}
+		labelIndent := indent + "  "
+		labelAt := i + 1
+		for j := i + 1; j < end; j++ {
+			k := regKeyLine.FindStringSubmatch(lines[j])
+			if k == nil || len(k[1]) <= len(indent) {
+				break
+			}
+			labelIndent, labelAt = k[1], j+1
+			if k[3] == key {
+				lines[j] = fmt.Sprintf("%s%s: %s", labelIndent, key, strconv.Quote(value))
+				return strings.Join(lines, "\n"), nil
+			}
+		}
+		return strings.Join(insertLine(lines, labelAt, fmt.Sprintf("%s%s: %s", labelIndent, key, strconv.Quote(value))), "\n"), nil
+	}
+	lines = insertLine(lines, at, indent+"labels:")
+	return strings.Join(insertLine(lines, at+1, fmt.Sprintf("%s  %s: %s", indent, key, strconv.Quote(value))), "\n"), nil
+}
+
+//updateConfigVersion points the version of the template config.yml at version.
+func updateConfigVersion(configFile, version string) error {
+	return setTopLevelField(configFile, "version", version)
+}
+
+//setTopLevelField sets a top level key of a YAML file, if the file exists.
+func setTopLevelField(file, key, value string) error {
+	content, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	line := key + ": " + strconv.Quote(value)
+	lines := strings.Split(string(content), "\n")
+	found := false
+	for i, l := range lines {
+		if strings.HasPrefix(l, key+":") {
+			lines[i] = line
+			found = true
+		}
+	}
+	if !found {
+		lines = insertLine(lines, len(lines), line)
+		if len(lines) > 1 && lines[len(lines)-2] == "" {
+			lines[len(lines)-2], lines[len(lines)-1] = lines[len(lines)-1], ""
+		}
+	}
+	return ioutil.WriteFile(file, []byte(strings.Join(lines, "\n")), 0644)
+}
+
+func insertLine(lines []string, at int, line string) []string {
+	lines = append(lines, "")
+	copy(lines[at+1:], lines[at:])
+	lines[at] = line
+	return lines
+}
+
+func unquote(value string) string {
+	value = strings.TrimSpace(value)
+	if i := strings.Index(value, " #"); i >= 0 && !strings.HasPrefix(value, `"`) && !strings.HasPrefix(value, "'") {
+		value = strings.TrimSpace(value[:i])
+	}
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
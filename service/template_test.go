@@ -0,0 +1,80 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rancher/rancher-upgrader/model"
+)
+
+const testRancherCompose = `.catalog:
+  name: web
+  version: "1.2.3"
+  questions:
+    - variable: REPLICAS
+      type: int
+      default: 1
+    - variable: DEBUG
+      type: boolean
+web:
+  scale: ${REPLICAS}
+`
+
+func TestRenderFromPrevious(t *testing.T) {
+	dir, err := ioutil.TempDir("", "template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "0"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "0", "docker-compose.yml"), []byte("web:\n  image: 'org/web:1.2.3' # app\nlb:\n  image: rancher/lb-service-haproxy:v0.7.9\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "0", "rancher-compose.yml"), []byte(testRancherCompose), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.yml"), []byte("name: web\nversion: 1.2.3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &model.CatalogUpgrade{
+		Variables: map[string]string{"image": "org/web:1.2.4", "REPLICAS": "2", "DEBUG": "true"},
+	}
+	if err := renderFromPrevious(dir, 0, config); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(config.DockerCompose, "  image: 'org/web:1.2.4' # app\n") ||
+		!strings.Contains(config.DockerCompose, "rancher/lb-service-haproxy:v0.7.9") {
+		t.Errorf("unexpected docker-compose.yml:\n%s", config.DockerCompose)
+	}
+	for _, expected := range []string{
+		"  version: \"1.2.4\"\n  upgrade_from: \"1.2.3\"\n",
+		"      default: \"2\"\n    - variable: DEBUG",
+		"    - variable: DEBUG\n      default: \"true\"\n      type: boolean",
+	} {
+		if !strings.Contains(config.RancherCompose, expected) {
+			t.Errorf("rancher-compose.yml misses %q:\n%s", expected, config.RancherCompose)
+		}
+	}
+	configYml, _ := ioutil.ReadFile(filepath.Join(dir, "config.yml"))
+	if string(configYml) != "name: web\nversion: \"1.2.4\"\n" {
+		t.Errorf("unexpected config.yml:\n%s", configYml)
+	}
+}
+
+func TestBumpVersion(t *testing.T) {
+	for in, expected := range map[string]string{"1.2.3": "1.2.4", "v2-rc9": "v2-rc10", "7": "8", "1.0-beta": "1.1-beta"} {
+		if v, err := bumpVersion(in); err != nil || v != expected {
+			t.Errorf("%s: expected %s, got %s (%v)", in, expected, v, err)
+		}
+	}
+	if _, err := bumpVersion("latest"); err == nil {
+		t.Error("expected error for version without number")
+	}
+}
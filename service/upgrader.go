@@ -1,27 +1,29 @@
 package service
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"net/http"
-	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/rancher/go-rancher/catalog"
-
 	log "github.com/Sirupsen/logrus"
 	"github.com/pkg/errors"
 	"github.com/rancher/go-rancher/v2"
 	"github.com/rancher/rancher-upgrader/model"
 )
 
-var regTag = regexp.MustCompile(`^[\w]+[\w.-]*`)
+var (
+	regTag        = regexp.MustCompile(`^[\w]+[\w.-]*`)
+	regProjectUrl = regexp.MustCompile(`/projects/([^/]+)/?$`)
+
+	//waitInterval is how often transitioning services and stacks are checked.
+	waitInterval = 5 * time.Second
+)
 
-func UpgradeServices(apiClient *client.RancherClient, config *model.ServiceUpgrade, pushedImage string) {
+//UpgradeServices upgrades the services matching the selector to pushedImage, the services that
+//fail don't stop the others from being upgraded.
+func UpgradeServices(api RancherAPI, config *model.ServiceUpgrade, pushedImage string) error {
 	var key, value string
 	var secondaryPresent, primaryPresent bool
 	serviceSelector := make(map[string]string)
@@ -32,13 +34,14 @@ func UpgradeServices(apiClient *client.RancherClient, config *model.ServiceUpgra
 	batchSize := config.BatchSize
 	intervalMillis := config.IntervalMillis
 	startFirst := config.StartFirst
-	services, err := apiClient.Service.List(&client.ListOpts{})
+	services, err := api.ListServices()
 	if err != nil {
-		log.Fatalf("Error %v in listing services", err)
-		return
+		log.Errorf("Error %v in listing services", err)
+		return err
 	}
 
-	for _, service := range services.Data {
+	var failed []string
+	for _, service := range services {
 		secondaryPresent = false
 		primaryPresent = false
 		primaryLabels := service.LaunchConfig.Labels
@@ -75,8 +78,8 @@ func UpgradeServices(apiClient *client.RancherClient, config *model.ServiceUpgra
 			continue
 		}
 
-		func(service client.Service, apiClient *client.RancherClient, newLaunchConfig *client.LaunchConfig,
-			secConfigs []client.SecondaryLaunchConfig, primaryPresent bool, secondaryPresent bool) {
+		err := func(service client.Service, api RancherAPI, newLaunchConfig *client.LaunchConfig,
+			secConfigs []client.SecondaryLaunchConfig, primaryPresent bool, secondaryPresent bool) error {
 			upgStrategy := &client.InServiceUpgradeStrategy{
 				BatchSize:      batchSize,
 				IntervalMillis: intervalMillis * 1000,
@@ -91,116 +94,137 @@ func UpgradeServices(apiClient *client.RancherClient, config *model.ServiceUpgra
 				upgStrategy.SecondaryLaunchConfigs = secConfigs
 			}
 
-			upgradedService, err := apiClient.Service.ActionUpgrade(&service, &client.ServiceUpgrade{
+			upgradedService, err := api.UpgradeService(&service, &client.ServiceUpgrade{
 				InServiceStrategy: upgStrategy,
 			})
 			if err != nil {
-				log.Fatalf("Error %v in upgrading service %s", err, service.Id)
-				return
+				log.Errorf("Error %v in upgrading service %s", err, service.Id)
+				return err
 			}
 
-			if err := wait(apiClient, upgradedService); err != nil {
-				log.Fatal(err)
-				return
+			if err := wait(api, upgradedService, config.WaitTimeout); err != nil {
+				log.Error(err)
+				return err
 			}
 
 			if upgradedService.State != "upgraded" {
-				return
+				return fmt.Errorf("upgrade service %s failed, service is %s", upgradedService.Name, upgradedService.State)
 			}
 
-			_, err = apiClient.Service.ActionFinishupgrade(upgradedService)
+			_, err = api.FinishServiceUpgrade(upgradedService)
 			if err != nil {
-				log.Fatalf("Error %v in finishUpgrade of service %s", err, upgradedService.Id)
-				return
+				log.Errorf("Error %v in finishUpgrade of service %s", err, upgradedService.Id)
+				return err
 			}
 			log.Infof("upgrade service '%s' success", upgradedService.Name)
-		}(service, apiClient, newLaunchConfig, secConfigs, primaryPresent, secondaryPresent)
+			return nil
+		}(service, api, newLaunchConfig, secConfigs, primaryPresent, secondaryPresent)
+		if err != nil {
+			failed = append(failed, service.Name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d services failed to upgrade: %s", len(failed), strings.Join(failed, ","))
 	}
+	return nil
 }
 
-func UpgradeStack(apiClient *client.RancherClient, config *model.StackUpgrade) error {
+func UpgradeStack(api RancherAPI, config *model.StackUpgrade) error {
 	stackName := config.StackName
+	if config.Secrets != nil {
+		env, err := config.Secrets.ResolveAll(config.Environment)
+		if err != nil {
+			return err
+		}
+		config.Environment = env
+	}
 	var toUpgradeStack *client.Stack
-	stacks, err := apiClient.Stack.List(&client.ListOpts{})
+	stacks, err := api.ListStacks()
 	if err != nil {
 		log.Errorf("Error %v in listing stacks", err)
 		return err
 	}
-	for _, stack := range stacks.Data {
-		if stack.Name == stackName {
-			toUpgradeStack = &stack
+	for i := range stacks {
+		if stacks[i].Name == stackName {
+			toUpgradeStack = &stacks[i]
 			break
 		}
 	}
 	if toUpgradeStack == nil {
+		if config.InstallIfMissing {
+			return installStack(api, config)
+		}
 		log.Errorf("Stack %v is not found.", stackName)
-		return err
+		return fmt.Errorf("stack %s is not found", stackName)
 	}
 
+	if config.ToLatestCatalog {
+		log.Infoln("refreshing catalog templates...")
+		if err = api.RefreshCatalog(); err != nil {
+			return err
+		}
+	}
+	return upgradeFoundStack(api, config, toUpgradeStack)
+}
+
+//upgradeFoundStack upgrades the existing stack, the catalog is expected to be refreshed already.
+func upgradeFoundStack(api RancherAPI, config *model.StackUpgrade, toUpgradeStack *client.Stack) error {
+	stackName := toUpgradeStack.Name
 	if config.ToLatestCatalog {
 		if toUpgradeStack.ExternalId == "" {
 			log.Error("stack is not deployed from catalog")
 			return errors.New("stack is not deployed from catalog")
 		}
-		log.Infoln("refreshing catalog templates...")
-		if err = refreshCatalog(apiClient, config); err != nil {
-			return err
-		}
 		if config.ExternalId == "" {
-			latestExtId, err := getTemplateLatestVersion(config, toUpgradeStack.ExternalId)
+			latestExtId, err := getTemplateLatestVersion(api, toUpgradeStack.ExternalId)
 			if err != nil {
 				return err
 			}
 			config.ExternalId = latestExtId
-			template, err := getTemplateVersion(config, latestExtId)
-			if err != nil {
-				return err
-			}
-			for k, v := range template.Files {
-				if strings.HasPrefix(k, "docker-compose") && config.DockerCompose == "" {
-					config.DockerCompose = v.(string)
-				} else if strings.HasPrefix(k, "rancher-compose") && config.RancherCompose == "" {
-					config.RancherCompose = v.(string)
-				}
-			}
-			if config.Environment == nil && toUpgradeStack.Environment != nil {
-				log.Infoln("using previous environment.")
-				config.Environment = toUpgradeStack.Environment
-			}
 		}
 
 		if config.ExternalId == toUpgradeStack.ExternalId {
-			log.Infoln("Latest template version already...")
+			log.Infof("stack '%s' is at the latest template version already", stackName)
 			return nil
 		}
 	}
 
+	if config.ExternalId != "" {
+		if err := applyTemplate(api, config, toUpgradeStack); err != nil {
+			return err
+		}
+	}
+
 	stackUpgrade := &client.StackUpgrade{
 		DockerCompose:  config.DockerCompose,
 		RancherCompose: config.RancherCompose,
 		ExternalId:     config.ExternalId,
 		Environment:    config.Environment,
 	}
-	stack, err := apiClient.Stack.ActionUpgrade(toUpgradeStack, stackUpgrade)
+	stack, err := api.UpgradeStack(toUpgradeStack, stackUpgrade)
+	if err != nil {
+		log.Errorf("Error %v in upgrading stack %s", err, stackName)
+		return err
+	}
 	/*
 		serviceIds := stack.ServiceIds
 
 		for _, id := range serviceIds {
-			service, err := apiClient.Service.ById(id)
+			service, err := api.GetService(id)
 			if err != nil {
 				log.Fatalf("Error %v in upgrading stacks", err)
 				return err
 			}
-			if err := wait(apiClient, service); err != nil {
+			if err := wait(api, service, config.WaitTimeout); err != nil {
 				log.Fatal(err)
 				return err
 			}
 		}
-		if err := apiClient.Reload(&stack.Resource, stack); err != nil {
+		if err := api.ReloadStack(stack); err != nil {
 			return err
 		}
 	*/
-	if err := waitStack(apiClient, stack); err != nil {
+	if err := waitStack(api, stack, config.WaitTimeout); err != nil {
 		log.Error(err.Error())
 		return err
 	}
@@ -209,7 +233,7 @@ func UpgradeStack(apiClient *client.RancherClient, config *model.StackUpgrade) e
 		return errors.New("upgrade stack failed.")
 	}
 
-	_, err = apiClient.Stack.ActionFinishupgrade(stack)
+	_, err = api.FinishStackUpgrade(stack)
 	if err != nil {
 		log.Errorf("Error %v in finishUpgrade of stack %s", err, stack.Name)
 		return err
@@ -218,75 +242,83 @@ func UpgradeStack(apiClient *client.RancherClient, config *model.StackUpgrade) e
 	return nil
 }
 
-func getProjId(config *model.StackUpgrade) (string, error) {
-
-	client := &http.Client{}
-
-	requestURL := config.CattleUrl
+//installStack creates the stack from the compose files or the catalog template given in config.
+func installStack(api RancherAPI, config *model.StackUpgrade) error {
+	if config.ExternalId != "" {
+		if err := applyTemplate(api, config, nil); err != nil {
+			return err
+		}
+	}
+	if config.DockerCompose == "" {
+		return fmt.Errorf("stack %s is not found and no compose file or catalog template is given to install it", config.StackName)
+	}
 
-	req, err := http.NewRequest("GET", requestURL, nil)
+	log.Infof("stack '%s' is not found, installing it", config.StackName)
+	stack, err := api.CreateStack(&client.Stack{
+		Name:           config.StackName,
+		DockerCompose:  config.DockerCompose,
+		RancherCompose: config.RancherCompose,
+		ExternalId:     config.ExternalId,
+		Environment:    config.Environment,
+		StartOnCreate:  true,
+	})
 	if err != nil {
-		log.Infoln("Cannot connect to the rancher server. Please check the rancher server URL")
-		return "", err
+		log.Errorf("Error %v in creating stack %s", err, config.StackName)
+		return err
 	}
-	req.SetBasicAuth(config.AccessKey, config.SecretKey)
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Infoln("Cannot connect to the rancher server. Please check the rancher server URL")
-		return "", err
+
+	if err := waitStack(api, stack, config.WaitTimeout); err != nil {
+		log.Error(err.Error())
+		return err
 	}
-	defer resp.Body.Close()
-	userid := resp.Header.Get("X-Api-User-Id")
-	if userid == "" {
-		log.Infoln("Cannot get userid")
-		err := errors.New("Forbidden")
-		return "Forbidden", err
 
+	if stack.State != "active" {
+		return fmt.Errorf("install stack failed, stack %s is %s", stack.Name, stack.State)
 	}
-	return userid, nil
+	log.Infof("install stack '%s' success", stack.Name)
+	return nil
 }
 
-func getTemplateVersion(config *model.StackUpgrade, externalId string) (*catalog.TemplateVersion, error) {
-	u, err := url.Parse(config.CattleUrl)
+//applyTemplate takes the compose files config doesn't give and the questions from the catalog
+//template version in config.ExternalId and answers them, reusing the previous answers of stack
+//if it exists.
+func applyTemplate(api RancherAPI, config *model.StackUpgrade, stack *client.Stack) error {
+	template, err := api.TemplateVersion(config.ExternalId)
 	if err != nil {
-		return &catalog.TemplateVersion{}, err
+		return err
 	}
-	projId, err := getProjId(config)
-	if err != nil {
-		return &catalog.TemplateVersion{}, err
+	for k, v := range template.Files {
+		if strings.HasPrefix(k, "docker-compose") && config.DockerCompose == "" {
+			config.DockerCompose = v.(string)
+		} else if strings.HasPrefix(k, "rancher-compose") && config.RancherCompose == "" {
+			config.RancherCompose = v.(string)
+		}
 	}
-	trimExternalId := externalId[strings.LastIndex(externalId, "/")+1:]
-	templateUrl := fmt.Sprintf("%s://%s/v1-catalog/templates/%s?projectId=%s", u.Scheme, u.Host, trimExternalId, projId)
 
-	client := &http.Client{}
-
-	req, err := http.NewRequest("GET", templateUrl, nil)
-	if err != nil {
-		log.Infoln("Cannot connect to the rancher server. Please check the rancher server URL")
-		return &catalog.TemplateVersion{}, err
-	}
-	req.SetBasicAuth(config.AccessKey, config.SecretKey)
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Infoln("Cannot connect to the rancher server. Please check the rancher server URL")
-		return &catalog.TemplateVersion{}, err
+	var previous map[string]interface{}
+	if stack != nil {
+		previous = stack.Environment
+		if stack.ExternalId != "" {
+			current, err := api.TemplateVersion(stack.ExternalId)
+			if err != nil {
+				return err
+			}
+			if diff := DiffQuestions(current.Questions, template.Questions); !diff.Empty() {
+				log.Infof("template questions changed: %s", diff)
+			}
+		}
 	}
-	defer resp.Body.Close()
-
-	byteContent, err := ioutil.ReadAll(resp.Body)
+	answers, err := MergeAnswers(template.Questions, previous, config.Environment)
 	if err != nil {
-		return &catalog.TemplateVersion{}, err
-	}
-	tempObj := &catalog.TemplateVersion{}
-	if err := json.Unmarshal(byteContent, tempObj); err != nil {
-		return &catalog.TemplateVersion{}, errors.Wrap(err, fmt.Sprintf("getTemplateLatestVersion error, Failed to parse: %s", byteContent))
+		return errors.Wrap(err, "answering template questions failed")
 	}
-	return tempObj, nil
+	config.Environment = answers
+	return nil
 }
 
-func getTemplateLatestVersion(config *model.StackUpgrade, externalId string) (string, error) {
+func getTemplateLatestVersion(api RancherAPI, externalId string) (string, error) {
 
-	tempObj, err := getTemplateVersion(config, externalId)
+	tempObj, err := api.TemplateVersion(externalId)
 	if err != nil {
 		return "", err
 	}
@@ -311,32 +343,16 @@ func getTemplateLatestVersion(config *model.StackUpgrade, externalId string) (st
 	return "catalog://" + retV, nil
 }
 
-func refreshCatalog(apiClient *client.RancherClient, config *model.StackUpgrade) error {
-	u, err := url.Parse(config.CattleUrl)
-	if err != nil {
-		return err
-	}
-	projId, err := getProjId(config)
-	if err != nil {
-		return err
-	}
-	refreshUrl := fmt.Sprintf("%s://%s/v1-catalog/templates?action=refresh&projectId=%s", u.Scheme, u.Host, projId)
-
-	if err := apiClient.Post(refreshUrl, nil, nil); err != nil {
-		return err
-	}
-	return nil
-}
-
-func wait(apiClient *client.RancherClient, service *client.Service) error {
-	for i := 0; i < 36; i++ {
-		if err := apiClient.Reload(&service.Resource, service); err != nil {
+func wait(api RancherAPI, service *client.Service, timeout time.Duration) error {
+	deadline := time.Now().Add(waitTimeout(timeout))
+	for {
+		if err := api.ReloadService(service); err != nil {
 			return err
 		}
-		if service.Transitioning != "yes" {
+		if service.Transitioning != "yes" || time.Now().After(deadline) {
 			break
 		}
-		time.Sleep(5 * time.Second)
+		time.Sleep(waitInterval)
 	}
 
 	switch service.Transitioning {
@@ -349,15 +365,16 @@ func wait(apiClient *client.RancherClient, service *client.Service) error {
 	}
 }
 
-func waitStack(apiClient *client.RancherClient, stack *client.Stack) error {
-	for i := 0; i < 36; i++ {
-		if err := apiClient.Reload(&stack.Resource, stack); err != nil {
+func waitStack(api RancherAPI, stack *client.Stack, timeout time.Duration) error {
+	deadline := time.Now().Add(waitTimeout(timeout))
+	for {
+		if err := api.ReloadStack(stack); err != nil {
 			return err
 		}
-		if stack.Transitioning != "yes" {
+		if stack.Transitioning != "yes" || time.Now().After(deadline) {
 			break
 		}
-		time.Sleep(5 * time.Second)
+		time.Sleep(waitInterval)
 	}
 
 	switch stack.Transitioning {
@@ -370,6 +387,14 @@ func waitStack(apiClient *client.RancherClient, stack *client.Stack) error {
 	}
 }
 
+//waitTimeout is how long upgrades are waited for, 3 minutes unless set.
+func waitTimeout(timeout time.Duration) time.Duration {
+	if timeout <= 0 {
+		return 3 * time.Minute
+	}
+	return timeout
+}
+
 func TemplateURLPath(path string) (string, string, string, string, bool) {
 	pathSplit := strings.Split(path, ":")
 	switch len(pathSplit) {
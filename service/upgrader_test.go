@@ -1,21 +1,186 @@
 package service
 
-/*
-func TestUpgrade(t *testing.T) {
-	factory := cmd.ClientFactory{}
-	ctx := &cli.Context{}
-	cli.
-	ctx.GlobalSet("url", "http://47.52.106.231:8080/v1")
-	ctx.GlobalSet("env", "1a5")
-	ctx.GlobalSet("access-key", "6DA28511BEF69B450967")
-	ctx.GlobalSet("secret-key", "gdCAakbtgPABfcUQg5oLkob1kuhqTvZDdSWEW6wW")
-	apiClient, _ := factory.GetClient(ctx)
-	config := &model.ServiceUpgrade{
-		ServiceSelector: map[string]string{"label": "test"},
-		BatchSize:       1,
-		IntervalMillis:  2,
-		StartFirst:      false,
-	}
-	UpgradeServices(apiClient, config, "nginx:latest")
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rancher/go-rancher/catalog"
+	"github.com/rancher/go-rancher/v2"
+	"github.com/rancher/rancher-upgrader/model"
+	"github.com/rancher/rancher-upgrader/ranchertest"
+)
+
+func TestUpgradeServices(t *testing.T) {
+	api := &fakeAPI{
+		services: []client.Service{
+			{Resource: client.Resource{Id: "1s1"}, Name: "web", LaunchConfig: &client.LaunchConfig{ImageUuid: "docker:web:1", Labels: map[string]interface{}{"app": "web"}}},
+			{Resource: client.Resource{Id: "1s2"}, Name: "db", LaunchConfig: &client.LaunchConfig{ImageUuid: "docker:db:1", Labels: map[string]interface{}{"app": "db"}}},
+			{Resource: client.Resource{Id: "1s3"}, Name: "web-canary", LaunchConfig: &client.LaunchConfig{ImageUuid: "docker:web:1", Labels: map[string]interface{}{"app": "web"}}},
+		},
+		fail: map[string]bool{"web-canary": true},
+	}
+	config := &model.ServiceUpgrade{ServiceSelector: map[string]string{"app": "web"}, BatchSize: 1}
+
+	err := UpgradeServices(api, config, "web:2")
+	if err == nil || !strings.Contains(err.Error(), "web-canary") {
+		t.Errorf("expected web-canary to fail, got %v", err)
+	}
+	web, _ := api.GetService("1s1")
+	if web.LaunchConfig.ImageUuid != "docker:web:2" || web.State != "active" {
+		t.Errorf("expected web upgraded to web:2 and active, got %s %s", web.LaunchConfig.ImageUuid, web.State)
+	}
+	if db, _ := api.GetService("1s2"); db.LaunchConfig.ImageUuid != "docker:db:1" {
+		t.Errorf("db doesn't match the selector and should be left alone, got %s", db.LaunchConfig.ImageUuid)
+	}
+	expected := "upgrade service web,finish service web,upgrade service web-canary"
+	if calls := strings.Join(api.calls, ","); calls != expected {
+		t.Errorf("expected calls %s, got %s", expected, calls)
+	}
+}
+
+func templateFixture() map[string]*catalog.TemplateVersion {
+	return map[string]*catalog.TemplateVersion{
+		"lib:web:1": {
+			Files:               map[string]interface{}{"docker-compose.yml": "web: {image: web:1}"},
+			UpgradeVersionLinks: map[string]interface{}{"2": "http://rancher/v1-catalog/templates/lib:web:2"},
+		},
+		"lib:web:2": {
+			Files:     map[string]interface{}{"docker-compose.yml": "web: {image: web:2}", "rancher-compose.yml": ".catalog: {version: 2}"},
+			Questions: []catalog.Question{{Variable: "REPLICAS", Type: "int", Default: "1"}},
+		},
+	}
+}
+
+func TestUpgradeStackToLatest(t *testing.T) {
+	api := &fakeAPI{
+		stacks: []client.Stack{
+			{Resource: client.Resource{Id: "1st1"}, Name: "web", ExternalId: "catalog://lib:web:1", Environment: map[string]interface{}{"REPLICAS": "3"}},
+		},
+		templates: templateFixture(),
+	}
+	config := &model.StackUpgrade{StackName: "web", ToLatestCatalog: true}
+	if err := UpgradeStack(api, config); err != nil {
+		t.Fatal(err)
+	}
+	stack, _ := api.GetStack("1st1")
+	if stack.ExternalId != "catalog://lib:web:2" || stack.State != "active" {
+		t.Errorf("expected stack at lib:web:2 and active, got %s %s", stack.ExternalId, stack.State)
+	}
+	if stack.DockerCompose != "web: {image: web:2}" {
+		t.Errorf("expected the compose file of the template, got %q", stack.DockerCompose)
+	}
+	if stack.Environment["REPLICAS"] != "3" {
+		t.Errorf("expected the previous answers to be kept, got %v", stack.Environment)
+	}
+	if api.refreshes != 1 {
+		t.Errorf("expected the catalog to be refreshed once, got %d", api.refreshes)
+	}
+
+	config = &model.StackUpgrade{StackName: "web", ToLatestCatalog: true}
+	if err := UpgradeStack(api, config); err != nil {
+		t.Fatal(err)
+	}
+	if len(api.calls) != 2 {
+		t.Errorf("stack at the latest version should not be upgraded again, got calls %v", api.calls)
+	}
+}
+
+func TestUpgradeStackWithComposeFile(t *testing.T) {
+	api := &fakeAPI{
+		stacks: []client.Stack{
+			{Resource: client.Resource{Id: "1st1"}, Name: "web", ExternalId: "catalog://lib:web:1", Environment: map[string]interface{}{"REPLICAS": "3"}},
+		},
+		templates: templateFixture(),
+	}
+	config := &model.StackUpgrade{StackName: "web", ToLatestCatalog: true, DockerCompose: "web: {image: web:custom}"}
+	if err := UpgradeStack(api, config); err != nil {
+		t.Fatal(err)
+	}
+	stack, _ := api.GetStack("1st1")
+	if stack.DockerCompose != "web: {image: web:custom}" || stack.RancherCompose != ".catalog: {version: 2}" {
+		t.Errorf("expected the given compose file and the template rancher-compose, got %q %q", stack.DockerCompose, stack.RancherCompose)
+	}
+	if stack.Environment["REPLICAS"] != "3" {
+		t.Errorf("expected the previous answers to be kept, got %v", stack.Environment)
+	}
+}
+
+func TestUpgradeStackFailure(t *testing.T) {
+	api := &fakeAPI{
+		stacks:    []client.Stack{{Resource: client.Resource{Id: "1st1"}, Name: "web", ExternalId: "catalog://lib:web:1"}},
+		templates: templateFixture(),
+		fail:      map[string]bool{"web": true},
+	}
+	if err := UpgradeStack(api, &model.StackUpgrade{StackName: "web", ToLatestCatalog: true}); err == nil {
+		t.Error("expected the failed upgrade to be reported")
+	}
+	for _, call := range api.calls {
+		if strings.HasPrefix(call, "finish") {
+			t.Errorf("a failed upgrade must not be finished, got calls %v", api.calls)
+		}
+	}
+}
+
+func TestInstallStack(t *testing.T) {
+	api := &fakeAPI{templates: templateFixture()}
+	config := &model.StackUpgrade{StackName: "web", ExternalId: "catalog://lib:web:2"}
+	if err := UpgradeStack(api, config); err == nil {
+		t.Error("expected a missing stack to fail without install-if-missing")
+	}
+
+	config = &model.StackUpgrade{
+		StackName:        "web",
+		ExternalId:       "catalog://lib:web:2",
+		InstallIfMissing: true,
+		Environment:      map[string]interface{}{"REPLICAS": "2"},
+	}
+	if err := UpgradeStack(api, config); err != nil {
+		t.Fatal(err)
+	}
+	stacks, _ := api.ListStacks()
+	if len(stacks) != 1 || stacks[0].ExternalId != "catalog://lib:web:2" || stacks[0].Environment["REPLICAS"] != "2" {
+		t.Errorf("expected web installed from lib:web:2, got %+v", stacks)
+	}
+}
+
+func TestUpgradeAgainstServer(t *testing.T) {
+	defer func(interval time.Duration) { waitInterval = interval }(waitInterval)
+	waitInterval = time.Millisecond
+
+	s := ranchertest.NewServer()
+	defer s.Close()
+	s.PageSize = 1
+	s.AddService(client.Service{Name: "web", LaunchConfig: &client.LaunchConfig{ImageUuid: "docker:web:1", Labels: map[string]interface{}{"app": "web"}}})
+	s.AddService(client.Service{Name: "worker", LaunchConfig: &client.LaunchConfig{ImageUuid: "docker:web:1", Labels: map[string]interface{}{"app": "web"}}})
+	s.AddStack(client.Stack{Name: "shop", ExternalId: "catalog://lib:web:1"})
+	for id, tv := range templateFixture() {
+		s.AddTemplateVersion(id, *tv)
+	}
+	s.AddTemplateVersion("lib:web:1", *templateFixture()["lib:web:1"], "lib:web:2")
+	s.SetBehavior("web", ranchertest.Behavior{Polls: 3})
+	s.SetBehavior("worker", ranchertest.Behavior{Polls: 1, Fail: "health check failed"})
+	s.SetBehavior("shop", ranchertest.Behavior{Polls: 2})
+
+	api, err := NewRancherAPI(s.EnvUrl(), "", "", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = UpgradeServices(api, &model.ServiceUpgrade{ServiceSelector: map[string]string{"app": "web"}, BatchSize: 1}, "web:2")
+	if err == nil || !strings.Contains(err.Error(), "worker") {
+		t.Errorf("expected worker to fail, got %v", err)
+	}
+	if web, _ := s.Service("web"); web.State != "active" || web.LaunchConfig.ImageUuid != "docker:web:2" {
+		t.Errorf("expected web active at web:2, got %s %s", web.State, web.LaunchConfig.ImageUuid)
+	}
+
+	if err := UpgradeStack(api, &model.StackUpgrade{StackName: "shop", ToLatestCatalog: true}); err != nil {
+		t.Fatal(err)
+	}
+	if shop, _ := s.Stack("shop"); shop.State != "active" || shop.ExternalId != "catalog://lib:web:2" {
+		t.Errorf("expected shop active at lib:web:2, got %s %s", shop.State, shop.ExternalId)
+	}
+	if s.Refreshes() != 1 {
+		t.Errorf("expected one catalog refresh, got %d", s.Refreshes())
+	}
 }
-*/
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rancher/go-rancher/catalog"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var regVariableRef = regexp.MustCompile(`\$(\$|\{([A-Za-z_][A-Za-z0-9_]*)([^}]*)\}|([A-Za-z_][A-Za-z0-9_]*))`)
+
+//questionTypes are the question types Rancher catalogs support.
+var questionTypes = map[string]bool{
+	"string": true, "multiline": true, "password": true, "int": true, "float": true,
+	"boolean": true, "enum": true, "service": true, "certificate": true,
+}
+
+//ValidationError lists every problem found in a template version.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid template version:\n  %s", strings.Join(e.Problems, "\n  "))
+}
+
+type catalogSpec struct {
+	Catalog struct {
+		Name                  string             `yaml:"name"`
+		Version               string             `yaml:"version"`
+		Hidden                bool               `yaml:"hidden"`
+		MaximumRancherVersion string             `yaml:"maximum_rancher_version"`
+		Questions             []catalog.Question `yaml:"questions"`
+	} `yaml:".catalog"`
+}
+
+//ValidateTemplateVersion checks the compose files of a version of the template at templatePath
+//before it is published. The numbered version folder skip is not compared against, pass -1 for
+//a version that isn't written yet.
+func ValidateTemplateVersion(templatePath string, skip int, dockerCompose, rancherCompose string) error {
+	problems := append(templateProblems(templatePath), versionProblems(templatePath, skip, dockerCompose, rancherCompose)...)
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+//templateProblems checks the files every template needs besides its versions.
+func templateProblems(templatePath string) []string {
+	var problems []string
+	if _, err := os.Stat(filepath.Join(templatePath, "config.yml")); err != nil {
+		problems = append(problems, "template has no config.yml")
+	}
+	if icons, _ := filepath.Glob(filepath.Join(templatePath, "catalogIcon-*")); len(icons) == 0 {
+		problems = append(problems, "template has no catalogIcon-* icon")
+	}
+	return problems
+}
+
+func versionProblems(templatePath string, skip int, dockerCompose, rancherCompose string) []string {
+	var problems []string
+	problem := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	compose := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(dockerCompose), &compose); err != nil {
+		problem("docker-compose.yml: %v", err)
+	}
+	spec := &catalogSpec{}
+	if err := yaml.Unmarshal([]byte(rancherCompose), spec); err != nil {
+		problem("rancher-compose.yml: %v", err)
+	}
+
+	if spec.Catalog.Name == "" {
+		problem("rancher-compose.yml: .catalog has no name")
+	}
+	if spec.Catalog.Version == "" {
+		problem("rancher-compose.yml: .catalog has no version")
+	}
+	variables := map[string]bool{}
+	for i, q := range spec.Catalog.Questions {
+		if q.Variable == "" {
+			problem("rancher-compose.yml: question %d has no variable", i+1)
+			continue
+		}
+		if variables[q.Variable] {
+			problem("rancher-compose.yml: question '%s' is asked twice", q.Variable)
+		}
+		variables[q.Variable] = true
+		if !questionTypes[q.Type] {
+			problem("rancher-compose.yml: question '%s' has invalid type '%s'", q.Variable, q.Type)
+		} else if q.Type == "enum" && len(q.Options) == 0 {
+			problem("rancher-compose.yml: enum question '%s' has no options", q.Variable)
+		} else if q.Default != "" {
+			if err := validateAnswer(q, q.Default); err != nil {
+				problem("rancher-compose.yml: default of question '%s': %v", q.Variable, err)
+			}
+		}
+	}
+
+	for _, v := range undefinedVariables(dockerCompose, variables) {
+		problem("docker-compose.yml: references undefined answer '%s'", v)
+	}
+	lines := strings.Split(rancherCompose, "\n")
+	if start, end, _ := catalogSection(lines); start >= 0 {
+		lines = append(lines[:start:start], lines[end:]...)
+	}
+	for _, v := range undefinedVariables(strings.Join(lines, "\n"), variables) {
+		problem("rancher-compose.yml: references undefined answer '%s'", v)
+	}
+
+	if spec.Catalog.Version != "" {
+		versions, err := templateVersions(templatePath)
+		if err != nil {
+			problem("read existing versions: %v", err)
+		}
+		for folder, version := range versions {
+			if folder != skip && version == spec.Catalog.Version {
+				problem("version '%s' is already used by version folder %d", version, folder)
+			}
+		}
+	}
+	return problems
+}
+
+//ValidateTemplate checks every numbered version of the template at templatePath.
+func ValidateTemplate(templatePath string) error {
+	versions, err := templateVersions(templatePath)
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		return fmt.Errorf("%s has no numbered version folders", templatePath)
+	}
+	var folders []int
+	for folder := range versions {
+		folders = append(folders, folder)
+	}
+	sort.Ints(folders)
+
+	problems := templateProblems(templatePath)
+	for _, folder := range folders {
+		versionPath := filepath.Join(templatePath, strconv.Itoa(folder))
+		dockerCompose, err := ioutil.ReadFile(filepath.Join(versionPath, "docker-compose.yml"))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%d: %v", folder, err))
+			continue
+		}
+		rancherCompose, err := ioutil.ReadFile(filepath.Join(versionPath, "rancher-compose.yml"))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%d: %v", folder, err))
+			continue
+		}
+		for _, p := range versionProblems(templatePath, folder, string(dockerCompose), string(rancherCompose)) {
+			problems = append(problems, fmt.Sprintf("%d: %s", folder, p))
+		}
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+//templateVersions maps the numbered version folders of a template to their catalog version.
+func templateVersions(templatePath string) (map[int]string, error) {
+	files, err := ioutil.ReadDir(templatePath)
+	if err != nil {
+		return nil, err
+	}
+	versions := map[int]string{}
+	for _, f := range files {
+		folder, err := strconv.Atoi(f.Name())
+		if err != nil || !f.IsDir() {
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(templatePath, f.Name(), "rancher-compose.yml"))
+		if err != nil {
+			versions[folder] = ""
+			continue
+		}
+		versions[folder] = catalogField(string(content), "version")
+	}
+	return versions, nil
+}
+
+//undefinedVariables returns the ${VAR} and $VAR references without a question and a default.
+func undefinedVariables(content string, variables map[string]bool) []string {
+	seen := map[string]bool{}
+	var undefined []string
+	for _, m := range regVariableRef.FindAllStringSubmatch(content, -1) {
+		if m[1] == "$" {
+			continue
+		}
+		name := m[2] + m[4]
+		//${VAR:-default} and ${VAR-default} don't need an answer.
+		if strings.HasPrefix(m[3], "-") || strings.HasPrefix(m[3], ":-") {
+			continue
+		}
+		if !variables[name] && !seen[name] {
+			seen[name] = true
+			undefined = append(undefined, name)
+		}
+	}
+	return undefined
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateTemplateVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "0"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "0", "rancher-compose.yml"), []byte(testRancherCompose), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.yml"), []byte("name: web\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "catalogIcon-web.svg"), []byte("<svg/>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dockerCompose := "web:\n  image: org/web:${TAG:-latest}\n  command: echo $$HOME ${REPLICAS}\n"
+	valid := strings.Replace(testRancherCompose, `"1.2.3"`, `"1.2.4"`, 1)
+	if err := ValidateTemplateVersion(dir, -1, dockerCompose, valid); err != nil {
+		t.Errorf("expected valid version, got %v", err)
+	}
+
+	invalid := strings.Replace(testRancherCompose, "type: boolean", "type: bool", 1)
+	err = ValidateTemplateVersion(dir, -1, dockerCompose+"  user: ${USER}\n", invalid)
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("expected validation error, got %v", err)
+	}
+	for _, expected := range []string{
+		"question 'DEBUG' has invalid type 'bool'",
+		"docker-compose.yml: references undefined answer 'USER'",
+		"version '1.2.3' is already used by version folder 0",
+	} {
+		if !strings.Contains(verr.Error(), expected) {
+			t.Errorf("expected problem %q in:\n%v", expected, verr)
+		}
+	}
+	if len(verr.Problems) != 3 {
+		t.Errorf("expected 3 problems, got %v", verr.Problems)
+	}
+
+	if err := ValidateTemplateVersion(dir, -1, "web: [", valid); err == nil {
+		t.Error("expected error for broken docker-compose.yml")
+	}
+}
@@ -0,0 +1,180 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+	"github.com/rancher/go-rancher/v2"
+	"github.com/rancher/rancher-upgrader/model"
+)
+
+//Watcher upgrades the stacks of an environment to the latest version of their catalog template
+//as new versions appear, only while a maintenance window is open and at most
+//config.MaxUpgrades times per window.
+type Watcher struct {
+	api      RancherAPI
+	config   *model.AutoUpgrade
+	windows  []*Window
+	label    string
+	value    string
+	open     bool
+	upgrades int
+	//failed remembers the version a stack failed to upgrade to, it isn't tried again.
+	failed map[string]string
+}
+
+//NewWatcher returns a Watcher for the stacks selected by config. A stack is selected when it is
+//deployed from the catalog, matches config.StackNames and config.Template if given and one of
+//its services carries config.OptInLabel, given as key=value.
+func NewWatcher(api RancherAPI, config *model.AutoUpgrade) (*Watcher, error) {
+	w := &Watcher{api: api, config: config, failed: map[string]string{}}
+	for _, spec := range config.Windows {
+		window, err := ParseWindow(spec)
+		if err != nil {
+			return nil, err
+		}
+		w.windows = append(w.windows, window)
+	}
+	if config.OptInLabel != "" {
+		parts := strings.SplitN(config.OptInLabel, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("opt-in label '%s' needs the form key=value", config.OptInLabel)
+		}
+		w.label, w.value = parts[0], parts[1]
+	}
+	if config.Location == nil {
+		config.Location = time.Local
+	}
+	return w, nil
+}
+
+//windowOpen tells if a maintenance window is open at now, any time is without windows. The
+//upgrades counted against the limit start over whenever a window opens.
+func (w *Watcher) windowOpen(now time.Time) bool {
+	if len(w.windows) == 0 {
+		w.upgrades = 0
+		return true
+	}
+	now = now.In(w.config.Location)
+	for _, window := range w.windows {
+		if window.Contains(now) {
+			if !w.open {
+				log.Infof("maintenance window '%s' is open", window)
+				w.open, w.upgrades = true, 0
+			}
+			return true
+		}
+	}
+	w.open = false
+	return false
+}
+
+//Check upgrades the selected stacks with a newer template version if a maintenance window is
+//open at now, within what is left of the upgrade limit of the window. Without windows the limit
+//applies to every check.
+func (w *Watcher) Check(now time.Time) ([]StackResult, error) {
+	if !w.windowOpen(now) {
+		log.Debugf("no maintenance window is open at %s", now.In(w.config.Location).Format("Mon 15:04"))
+		return nil, nil
+	}
+	if w.config.MaxUpgrades > 0 && w.upgrades >= w.config.MaxUpgrades {
+		log.Debugf("upgrade limit of %d is reached for this window", w.config.MaxUpgrades)
+		return nil, nil
+	}
+
+	if err := w.api.RefreshCatalog(); err != nil {
+		return nil, errors.Wrap(err, "refresh catalog failed")
+	}
+	stacks, err := w.selectedStacks()
+	if err != nil {
+		return nil, err
+	}
+
+	var results []StackResult
+	var failed []string
+	for i := range stacks {
+		stack := &stacks[i]
+		latest, err := getTemplateLatestVersion(w.api, stack.ExternalId)
+		if err != nil {
+			log.Warnf("looking up the latest version of stack '%s' failed: %v", stack.Name, err)
+			continue
+		}
+		if latest == stack.ExternalId || w.failed[stack.Name] == latest {
+			continue
+		}
+		if w.config.MaxUpgrades > 0 && w.upgrades >= w.config.MaxUpgrades {
+			log.Infof("stack '%s' can be upgraded to %s, upgrade limit of %d is reached", stack.Name, latest, w.config.MaxUpgrades)
+			continue
+		}
+		log.Infof("upgrading stack '%s' from %s to %s", stack.Name, stack.ExternalId, latest)
+		w.upgrades++
+		config := &model.StackUpgrade{
+			StackName:       stack.Name,
+			ToLatestCatalog: true,
+			ExternalId:      latest,
+			WaitTimeout:     w.config.WaitTimeout,
+		}
+		result := StackResult{Stack: stack.Name, Err: upgradeFoundStack(w.api, config, stack)}
+		if result.Err != nil {
+			w.failed[stack.Name] = latest
+			failed = append(failed, stack.Name)
+		}
+		results = append(results, result)
+	}
+	if len(failed) > 0 {
+		return results, fmt.Errorf("%d stacks failed to upgrade: %s", len(failed), strings.Join(failed, ","))
+	}
+	return results, nil
+}
+
+//selectedStacks lists the stacks the watcher follows, by name.
+func (w *Watcher) selectedStacks() ([]client.Stack, error) {
+	var stacks []client.Stack
+	var err error
+	if w.config.Template != "" {
+		stacks, err = TemplateStacks(w.api, w.config.Template)
+	} else {
+		stacks, err = w.api.ListStacks()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	optedIn := map[string]bool{}
+	if w.label != "" {
+		services, err := w.api.ListServices()
+		if err != nil {
+			log.Errorf("Error %v in listing services", err)
+			return nil, err
+		}
+		for _, service := range services {
+			if service.LaunchConfig != nil && fmt.Sprint(service.LaunchConfig.Labels[w.label]) == w.value {
+				optedIn[service.StackId] = true
+			}
+		}
+	}
+	names := map[string]bool{}
+	for _, name := range w.config.StackNames {
+		names[name] = true
+	}
+
+	var selected []client.Stack
+	for _, stack := range stacks {
+		if !strings.HasPrefix(stack.ExternalId, "catalog://") {
+			continue
+		}
+		if len(names) > 0 && !names[stack.Name] {
+			continue
+		}
+		if w.label != "" && !optedIn[stack.Id] {
+			continue
+		}
+		selected = append(selected, stack)
+	}
+	sort.Slice(selected, func(i, j int) bool { return selected[i].Name < selected[j].Name })
+	return selected, nil
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rancher/go-rancher/catalog"
+	"github.com/rancher/go-rancher/v2"
+	"github.com/rancher/rancher-upgrader/model"
+)
+
+func TestWatcher(t *testing.T) {
+	templates := templateFixture()
+	templates["lib:infra*ipsec:1"] = &catalog.TemplateVersion{
+		Files:               map[string]interface{}{"docker-compose.yml": "ipsec: {image: ipsec:1}"},
+		UpgradeVersionLinks: map[string]interface{}{"2": "http://rancher/v1-catalog/templates/lib:infra*ipsec:2"},
+	}
+	templates["lib:infra*ipsec:2"] = &catalog.TemplateVersion{Files: map[string]interface{}{"docker-compose.yml": "ipsec: {image: ipsec:2}"}}
+	optIn := map[string]interface{}{"io.rancher.upgrader.auto_upgrade": "true"}
+	api := &fakeAPI{
+		stacks: []client.Stack{
+			{Resource: client.Resource{Id: "1st1"}, Name: "web", ExternalId: "catalog://lib:web:1"},
+			{Resource: client.Resource{Id: "1st2"}, Name: "ipsec", ExternalId: "catalog://lib:infra*ipsec:1", System: true},
+			{Resource: client.Resource{Id: "1st3"}, Name: "shop", ExternalId: "catalog://lib:web:1"},
+		},
+		services: []client.Service{
+			{Resource: client.Resource{Id: "1s1"}, Name: "web", StackId: "1st1", LaunchConfig: &client.LaunchConfig{Labels: optIn}},
+			{Resource: client.Resource{Id: "1s2"}, Name: "ipsec", StackId: "1st2", LaunchConfig: &client.LaunchConfig{Labels: optIn}},
+			{Resource: client.Resource{Id: "1s3"}, Name: "shop", StackId: "1st3", LaunchConfig: &client.LaunchConfig{}},
+		},
+		templates: templates,
+	}
+	w, err := NewWatcher(api, &model.AutoUpgrade{
+		OptInLabel:  "io.rancher.upgrader.auto_upgrade=true",
+		Windows:     []string{"* 22-23 * * *"},
+		Location:    time.UTC,
+		MaxUpgrades: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(clock string) time.Time {
+		t, _ := time.Parse("2006-01-02 15:04", "2017-06-05 "+clock)
+		return t
+	}
+	upgraded := func(results []StackResult) string {
+		var names []string
+		for _, r := range results {
+			names = append(names, r.Stack)
+		}
+		return strings.Join(names, ",")
+	}
+
+	if results, err := w.Check(at("12:00")); err != nil || len(results) > 0 || api.refreshes > 0 {
+		t.Errorf("expected nothing outside the window, got %v %v", results, err)
+	}
+	results, err := w.Check(at("22:00"))
+	if err != nil || upgraded(results) != "ipsec" {
+		t.Errorf("expected ipsec upgraded first, got %s %v", upgraded(results), err)
+	}
+	if results, _ := w.Check(at("22:05")); len(results) > 0 {
+		t.Errorf("expected the limit of the window to hold back web, got %s", upgraded(results))
+	}
+	w.Check(at("23:59"))
+	w.Check(at("00:00"))
+	results, err = w.Check(at("22:00"))
+	if err != nil || upgraded(results) != "web" {
+		t.Errorf("expected web upgraded in the next window, got %s %v", upgraded(results), err)
+	}
+	if shop, _ := api.GetStack("1st3"); shop.ExternalId != "catalog://lib:web:1" {
+		t.Errorf("shop has not opted in and should be left alone, got %s", shop.ExternalId)
+	}
+}
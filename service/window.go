@@ -0,0 +1,92 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Window is a maintenance window in cron syntax, "<minute> <hour> <day of month> <month> <day of
+//week>", open during every minute matching all five fields. Fields take *, numbers, ranges and
+//steps separated by commas, e.g. "* 0-6,20-23 * * 1-5" for weekday nights. Sunday is 0 or 7.
+type Window struct {
+	spec   string
+	fields [5]map[int]bool
+}
+
+var windowFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+//ParseWindow reads a maintenance window.
+func ParseWindow(spec string) (*Window, error) {
+	parts := strings.Fields(spec)
+	if len(parts) != len(windowFields) {
+		return nil, fmt.Errorf("window '%s' needs 5 fields: minute hour day-of-month month day-of-week", spec)
+	}
+	w := &Window{spec: spec}
+	for i, part := range parts {
+		values, err := parseWindowField(part, windowFields[i].min, windowFields[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("window '%s': %s: %v", spec, windowFields[i].name, err)
+		}
+		w.fields[i] = values
+	}
+	if w.fields[4][7] {
+		w.fields[4][0] = true
+	}
+	return w, nil
+}
+
+func parseWindowField(field string, min, max int) (map[int]bool, error) {
+	values := map[int]bool{}
+	for _, item := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(item[i+1:]); err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step in '%s'", item)
+			}
+			item = item[:i]
+		}
+		from, to := min, max
+		if item != "*" {
+			bounds := strings.SplitN(item, "-", 2)
+			var err error
+			if from, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("invalid value '%s'", item)
+			}
+			to = from
+			if len(bounds) == 2 {
+				if to, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("invalid value '%s'", item)
+				}
+			}
+		}
+		if from < min || to > max || from > to {
+			return nil, fmt.Errorf("'%s' is out of %d-%d", item, min, max)
+		}
+		for v := from; v <= to; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+//Contains tells if the window is open at t.
+func (w *Window) Contains(t time.Time) bool {
+	return w.fields[0][t.Minute()] && w.fields[1][t.Hour()] && w.fields[2][t.Day()] &&
+		w.fields[3][int(t.Month())] && w.fields[4][int(t.Weekday())]
+}
+
+func (w *Window) String() string {
+	return w.spec
+}
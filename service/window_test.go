@@ -0,0 +1,37 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindow(t *testing.T) {
+	w, err := ParseWindow("* 0-5,22-23 * * 1-5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		at   string
+		open bool
+	}{
+		{"2017-06-05 23:30", true},  //Monday
+		{"2017-06-05 06:00", false}, //Monday
+		{"2017-06-06 05:59", true},  //Tuesday
+		{"2017-06-10 23:30", false}, //Saturday
+	} {
+		at, _ := time.Parse("2006-01-02 15:04", test.at)
+		if w.Contains(at) != test.open {
+			t.Errorf("%s: expected open %v", test.at, test.open)
+		}
+	}
+
+	sunday, _ := time.Parse("2006-01-02 15:04", "2017-06-11 00:15")
+	if w, _ := ParseWindow("*/15 0 * * 7"); !w.Contains(sunday) {
+		t.Error("expected 7 to be Sunday")
+	}
+	for _, spec := range []string{"* * * *", "* 24 * * *", "* 5-1 * * *", "*/0 * * * *", "x * * * *"} {
+		if _, err := ParseWindow(spec); err == nil {
+			t.Errorf("%s: expected an error", spec)
+		}
+	}
+}
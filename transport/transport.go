@@ -0,0 +1,145 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+)
+
+//Options configure TLS and proxying of the HTTP calls to rancher.
+type Options struct {
+	CACert             string
+	ClientCert         string
+	ClientKey          string
+	InsecureSkipVerify bool
+	Proxy              string
+}
+
+//New builds a transport from the options. The CA certificate is trusted in addition to the
+//system ones, without an explicit proxy HTTP_PROXY, HTTPS_PROXY and NO_PROXY apply.
+func New(o *Options) (*http.Transport, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: o.InsecureSkipVerify}
+	if o.InsecureSkipVerify {
+		logrus.Warn("TLS certificates of the rancher server are not verified")
+	}
+	if o.CACert != "" {
+		pem, err := ioutil.ReadFile(o.CACert)
+		if err != nil {
+			return nil, errors.Wrap(err, "read CA certificate failed")
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", o.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if (o.ClientCert == "") != (o.ClientKey == "") {
+		return nil, errors.New("a client certificate needs both the certificate and its key")
+	}
+	if o.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(o.ClientCert, o.ClientKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "load client certificate failed")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if o.Proxy != "" {
+		proxyURL, err := url.Parse(o.Proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL '%s'", o.Proxy)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	return &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}, nil
+}
+
+//stock is the default transport of the process before any options were installed.
+var stock = http.DefaultTransport
+
+//scoped sends the calls to the rancher hosts through the transport built from the options and
+//every other call through the stock transport.
+type scoped struct {
+	mu      sync.RWMutex
+	hosts   map[string]bool
+	rancher http.RoundTripper
+}
+
+func (s *scoped) RoundTrip(req *http.Request) (*http.Response, error) {
+	s.mu.RLock()
+	ok := s.hosts[hostKey(req.URL)]
+	s.mu.RUnlock()
+	if ok {
+		return s.rancher.RoundTrip(req)
+	}
+	return stock.RoundTrip(req)
+}
+
+//Install makes the options apply to the HTTP calls of the process to the rancher servers at
+//urls. The rancher client builds its own http.Client on every request, all of them use the
+//default transport. Calls to any other host, e.g. a forge or a git remote, keep the stock one.
+func Install(o *Options, urls ...string) error {
+	t, err := New(o)
+	if err != nil {
+		return err
+	}
+	http.DefaultTransport = &scoped{hosts: map[string]bool{}, rancher: t}
+	for _, u := range urls {
+		AddHost(u)
+	}
+	return nil
+}
+
+//AddHost makes the installed options apply to the host of rawurl as well, e.g. of an
+//environment endpoint handed out by the server.
+func AddHost(rawurl string) {
+	s, ok := http.DefaultTransport.(*scoped)
+	if !ok || rawurl == "" {
+		return
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Host == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hosts[hostKey(u)] = true
+}
+
+//hostKey is the host and port of u, the port defaulting to the one of its scheme.
+func hostKey(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(strings.ToLower(u.Hostname()), port)
+}
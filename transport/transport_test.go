@@ -0,0 +1,85 @@
+package transport
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestCACert(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	caFile, err := ioutil.TempFile("", "ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(caFile.Name())
+	pem.Encode(caFile, &pem.Block{Type: "CERTIFICATE", Bytes: server.TLS.Certificates[0].Certificate[0]})
+	caFile.Close()
+
+	for _, test := range []struct {
+		options Options
+		ok      bool
+	}{
+		{Options{}, false},
+		{Options{CACert: caFile.Name()}, true},
+		{Options{InsecureSkipVerify: true}, true},
+	} {
+		tr, err := New(&test.options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := (&http.Client{Transport: tr}).Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err == nil) != test.ok {
+			t.Errorf("%+v: expected ok %v, got %v", test.options, test.ok, err)
+		}
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	for _, o := range []Options{
+		{ClientCert: "cert.pem"},
+		{Proxy: "not a url"},
+		{CACert: "/does/not/exist.pem"},
+	} {
+		if _, err := New(&o); err == nil {
+			t.Errorf("%+v: expected an error", o)
+		}
+	}
+}
+
+func TestInstallScopedToRancherHosts(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	rancher := httptest.NewTLSServer(handler)
+	defer rancher.Close()
+	other := httptest.NewTLSServer(handler)
+	defer other.Close()
+	defer func() { http.DefaultTransport = stock }()
+
+	if err := Install(&Options{InsecureSkipVerify: true}, rancher.URL+"/v2-beta"); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get(rancher.URL)
+	if err != nil {
+		t.Fatalf("expected the rancher server to skip verification, got %v", err)
+	}
+	resp.Body.Close()
+	if resp, err := http.Get(other.URL); err == nil {
+		resp.Body.Close()
+		t.Error("expected the certificate of another host to still be verified")
+	}
+
+	AddHost(other.URL)
+	resp, err = http.Get(other.URL)
+	if err != nil {
+		t.Fatalf("expected an added host to skip verification, got %v", err)
+	}
+	resp.Body.Close()
+}
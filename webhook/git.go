@@ -0,0 +1,97 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+//GitPush is a push of commits to a branch of a git repository.
+type GitPush struct {
+	Repository string
+	Branch     string
+	Commit     string
+}
+
+type gitPayload struct {
+	Ref   string `json:"ref"`
+	After string `json:"after"`
+	//GitHub
+	Repository struct {
+		CloneURL string `json:"clone_url"`
+	} `json:"repository"`
+	//GitLab
+	Project struct {
+		GitHTTPURL string `json:"git_http_url"`
+	} `json:"project"`
+}
+
+//ParseGitPush reads a GitHub or GitLab push webhook. Other events, like pings, pushes of tags
+//and deleted branches give no push.
+func ParseGitPush(header http.Header, body []byte) (*GitPush, error) {
+	if header.Get("X-GitHub-Event") != "push" && header.Get("X-Gitlab-Event") != "Push Hook" {
+		return nil, nil
+	}
+	p := &gitPayload{}
+	if err := json.Unmarshal(body, p); err != nil {
+		return nil, errors.New("payload is not JSON")
+	}
+	if !strings.HasPrefix(p.Ref, "refs/heads/") || strings.Trim(p.After, "0") == "" {
+		return nil, nil
+	}
+	push := &GitPush{
+		Repository: p.Repository.CloneURL,
+		Branch:     strings.TrimPrefix(p.Ref, "refs/heads/"),
+		Commit:     p.After,
+	}
+	if push.Repository == "" {
+		push.Repository = p.Project.GitHTTPURL
+	}
+	return push, nil
+}
+
+//GitHandler receives GitHub and GitLab push webhooks, authenticated like those of Handler or
+//with the secret as X-Gitlab-Token, and calls trigger for every push to branch.
+type GitHandler struct {
+	secret  string
+	branch  string
+	trigger func(push *GitPush)
+}
+
+//NewGitHandler returns a GitHandler calling trigger for pushes to branch.
+func NewGitHandler(secret, branch string, trigger func(push *GitPush)) *GitHandler {
+	return &GitHandler{secret: secret, branch: branch, trigger: trigger}
+}
+
+func (h *GitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxPayload))
+	if err != nil {
+		http.Error(w, "payload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if !authenticated(h.secret, r, body) {
+		logrus.Warnf("webhook from %s is not authenticated", r.RemoteAddr)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	push, err := ParseGitPush(r.Header, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if push == nil || push.Branch != h.branch {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	logrus.Infof("push of %s to branch %s", push.Commit, push.Branch)
+	h.trigger(push)
+	w.WriteHeader(http.StatusAccepted)
+}
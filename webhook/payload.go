@@ -0,0 +1,108 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+//Push is an image pushed to a registry.
+type Push struct {
+	//Host is the registry, empty for Docker Hub.
+	Host       string
+	Repository string
+	Tag        string
+	//CallbackURL is where Docker Hub expects to hear how the push was handled.
+	CallbackURL string
+}
+
+//Image is the reference services are upgraded to.
+func (p *Push) Image() string {
+	if p.Host != "" {
+		return p.Host + "/" + p.Repository + ":" + p.Tag
+	}
+	return p.Repository + ":" + p.Tag
+}
+
+type payload struct {
+	//Docker Hub
+	PushData *struct {
+		Tag string `json:"tag"`
+	} `json:"push_data"`
+	CallbackURL string `json:"callback_url"`
+	//Docker Hub sends an object, generic payloads a string.
+	Repository json.RawMessage `json:"repository"`
+
+	//Docker Registry v2 notifications
+	Events []struct {
+		Action string `json:"action"`
+		Target struct {
+			Repository string `json:"repository"`
+			Tag        string `json:"tag"`
+		} `json:"target"`
+		Request struct {
+			Host string `json:"host"`
+		} `json:"request"`
+	} `json:"events"`
+
+	//generic
+	Tag   string `json:"tag"`
+	Image string `json:"image"`
+}
+
+//ParsePushes reads the pushes of a Docker Hub webhook, a Docker Registry v2 notification or a
+//generic payload, which gives either "image" or "repository" and "tag".
+func ParsePushes(body []byte) ([]Push, error) {
+	p := &payload{}
+	if err := json.Unmarshal(body, p); err != nil {
+		return nil, errors.New("payload is not JSON")
+	}
+
+	switch {
+	case p.PushData != nil:
+		repo := struct {
+			RepoName string `json:"repo_name"`
+		}{}
+		if err := json.Unmarshal(p.Repository, &repo); err != nil || repo.RepoName == "" || p.PushData.Tag == "" {
+			return nil, errors.New("docker hub payload without repository or tag")
+		}
+		return []Push{{Repository: repo.RepoName, Tag: p.PushData.Tag, CallbackURL: p.CallbackURL}}, nil
+	case len(p.Events) > 0:
+		var pushes []Push
+		for _, e := range p.Events {
+			//blob pushes and manifest pushes by digest have no tag
+			if e.Action != "push" || e.Target.Tag == "" {
+				continue
+			}
+			pushes = append(pushes, Push{Host: e.Request.Host, Repository: e.Target.Repository, Tag: e.Target.Tag})
+		}
+		return pushes, nil
+	case p.Image != "":
+		push := parseImage(p.Image)
+		if push.Tag == "" {
+			return nil, errors.New("image without tag")
+		}
+		return []Push{push}, nil
+	default:
+		var repository string
+		if err := json.Unmarshal(p.Repository, &repository); err != nil || repository == "" || p.Tag == "" {
+			return nil, errors.New("unknown payload, expected docker hub, registry or {\"image\": ...}")
+		}
+		return []Push{{Repository: repository, Tag: p.Tag}}, nil
+	}
+}
+
+//parseImage splits host/repository:tag, the host is only taken when it looks like one.
+func parseImage(image string) Push {
+	push := Push{Repository: image}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		push.Repository, push.Tag = image[:i], image[i+1:]
+	}
+	if i := strings.Index(push.Repository, "/"); i > 0 {
+		host := push.Repository[:i]
+		if strings.ContainsAny(host, ".:") || host == "localhost" {
+			push.Host, push.Repository = host, push.Repository[i+1:]
+		}
+	}
+	return push
+}
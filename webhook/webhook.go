@@ -0,0 +1,297 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const maxPayload = 1 << 20
+
+//dockerHubHost is the only host push callbacks are sent to, anyone holding the webhook URL could
+//make the daemon send requests anywhere otherwise.
+const dockerHubHost = "registry.hub.docker.com"
+
+//Rule maps pushes of a repository to the services upgraded to the pushed image.
+type Rule struct {
+	//Repository is matched with or without the registry host.
+	Repository string `yaml:"repository"`
+	//Tag is a glob pattern, every tag matches without one.
+	Tag string `yaml:"tag,omitempty"`
+	//Selector picks the services by label, like --selector of the service command.
+	Selector   map[string]string `yaml:"selector"`
+	BatchSize  int64             `yaml:"batchSize,omitempty"`
+	Interval   int64             `yaml:"interval,omitempty"`
+	StartFirst bool              `yaml:"startFirst,omitempty"`
+}
+
+//Matches tells if the push is for the rule.
+func (r *Rule) Matches(push *Push) bool {
+	if r.Repository != push.Repository && r.Repository != push.Host+"/"+push.Repository {
+		return false
+	}
+	pattern := r.Tag
+	if pattern == "" {
+		pattern = "*"
+	}
+	ok, _ := path.Match(pattern, push.Tag)
+	return ok
+}
+
+//Key identifies the services of the rule by their selector.
+func (r *Rule) Key() string {
+	var labels []string
+	for k, v := range r.Selector {
+		labels = append(labels, k+"="+v)
+	}
+	sort.Strings(labels)
+	return strings.Join(labels, ",")
+}
+
+//LoadRules reads the rules file, a YAML document with a list of rules under "rules".
+func LoadRules(file string) ([]Rule, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	config := struct {
+		Rules []Rule `yaml:"rules"`
+	}{}
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		return nil, errors.Wrapf(err, "parse %s failed", file)
+	}
+	if len(config.Rules) == 0 {
+		return nil, fmt.Errorf("%s has no rules", file)
+	}
+	for i, r := range config.Rules {
+		if r.Repository == "" {
+			return nil, fmt.Errorf("rule %d has no repository", i+1)
+		}
+		//UpgradeServices matches services on a single label
+		if len(r.Selector) != 1 {
+			return nil, fmt.Errorf("rule %d for %s needs a selector of one label", i+1, r.Repository)
+		}
+		if _, err := path.Match(r.Tag, ""); err != nil {
+			return nil, fmt.Errorf("rule %d for %s: invalid tag pattern '%s'", i+1, r.Repository, r.Tag)
+		}
+	}
+	return config.Rules, nil
+}
+
+//Handler receives push webhooks and upgrades the services of the matching rules. Requests are
+//authenticated with the secret, given as "token" query parameter or X-Webhook-Token header, or
+//signing the payload with it in X-Hub-Signature-256 (sha256=<hex HMAC>).
+type Handler struct {
+	secret  string
+	rules   []Rule
+	upgrade func(rule Rule, image string) error
+	queue   *queue
+	client  *http.Client
+}
+
+//NewHandler returns a Handler running upgrade for every push matching a rule.
+func NewHandler(secret string, rules []Rule, upgrade func(rule Rule, image string) error) *Handler {
+	h := &Handler{
+		secret:  secret,
+		rules:   rules,
+		upgrade: upgrade,
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+	h.queue = newQueue(h.run)
+	return h
+}
+
+//job is an upgrade waiting in the queue.
+type job struct {
+	rule Rule
+	push Push
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxPayload))
+	if err != nil {
+		http.Error(w, "payload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if !authenticated(h.secret, r, body) {
+		logrus.Warnf("webhook from %s is not authenticated", r.RemoteAddr)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	pushes, err := ParsePushes(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	queued := []map[string]string{}
+	for _, push := range pushes {
+		matched := false
+		for _, rule := range h.rules {
+			if !rule.Matches(&push) {
+				continue
+			}
+			matched = true
+			logrus.Infof("push of %s queued for services %s", push.Image(), rule.Key())
+			h.queue.add(rule.Repository+" "+rule.Key(), job{rule: rule, push: push})
+			queued = append(queued, map[string]string{"image": push.Image(), "selector": rule.Key()})
+		}
+		if !matched {
+			logrus.Infof("no rule for push of %s", push.Image())
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{"queued": queued})
+}
+
+//authenticated tells if the request carries secret as "token" query parameter, X-Webhook-Token
+//or X-Gitlab-Token header or signs body with it in X-Hub-Signature-256.
+func authenticated(secret string, r *http.Request, body []byte) bool {
+	if secret == "" {
+		return false
+	}
+	for _, token := range []string{r.URL.Query().Get("token"), r.Header.Get("X-Webhook-Token"), r.Header.Get("X-Gitlab-Token")} {
+		if token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1 {
+			return true
+		}
+	}
+	signature := r.Header.Get("X-Hub-Signature-256")
+	if !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+//run upgrades the services of the job and reports back to Docker Hub.
+func (h *Handler) run(j job) {
+	logrus.Infof("upgrading services %s to %s", j.rule.Key(), j.push.Image())
+	err := h.upgrade(j.rule, j.push.Image())
+	if err != nil {
+		logrus.Errorf("upgrading services %s to %s failed: %v", j.rule.Key(), j.push.Image(), err)
+	} else {
+		logrus.Infof("upgraded services %s to %s", j.rule.Key(), j.push.Image())
+	}
+	if j.push.CallbackURL == "" {
+		return
+	}
+	if !dockerHubCallback(j.push.CallbackURL) {
+		logrus.Warnf("ignoring callback to %s, only https callbacks to %s are sent", j.push.CallbackURL, dockerHubHost)
+		return
+	}
+	h.callback(j.push.CallbackURL, err)
+}
+
+//dockerHubCallback tells if rawurl is an https URL of Docker Hub.
+func dockerHubCallback(rawurl string) bool {
+	u, err := url.Parse(rawurl)
+	if err != nil || u.User != nil {
+		return false
+	}
+	return u.Scheme == "https" && (u.Host == dockerHubHost || u.Host == dockerHubHost+":443")
+}
+
+func (h *Handler) callback(callbackURL string, upgradeErr error) {
+	result := map[string]string{"state": "success", "context": "rancher-upgrader", "description": "services upgraded"}
+	if upgradeErr != nil {
+		result["state"], result["description"] = "failure", upgradeErr.Error()
+	}
+	content, _ := json.Marshal(result)
+	resp, err := h.client.Post(callbackURL, "application/json", bytes.NewReader(content))
+	if err != nil {
+		logrus.Warnf("docker hub callback failed: %v", err)
+		return
+	}
+	resp.Body.Close()
+}
+
+//Wait blocks until the queued upgrades are done.
+func (h *Handler) Wait() {
+	h.queue.wait()
+}
+
+//queue runs one job at a time, in the order they were added, so rules selecting the same
+//services never upgrade them at once. A job waiting under the key, rule repository and
+//selector, of a newer one is replaced by it, only the latest push is worth upgrading to.
+type queue struct {
+	mu      sync.Mutex
+	running bool
+	order   []string
+	pending map[string]job
+	run     func(job)
+	wg      sync.WaitGroup
+}
+
+func newQueue(run func(job)) *queue {
+	return &queue{pending: map[string]job{}, run: run}
+}
+
+func (q *queue) add(key string, j job) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.running {
+		q.running = true
+		q.wg.Add(1)
+		go q.work(j)
+		return
+	}
+	if old, ok := q.pending[key]; ok {
+		logrus.Infof("push of %s replaces waiting push of %s", j.push.Image(), old.push.Image())
+	} else {
+		q.order = append(q.order, key)
+	}
+	q.pending[key] = j
+}
+
+func (q *queue) work(j job) {
+	defer q.wg.Done()
+	for {
+		q.run(j)
+		q.mu.Lock()
+		if len(q.order) == 0 {
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+		key := q.order[0]
+		q.order = q.order[1:]
+		j = q.pending[key]
+		delete(q.pending, key)
+		q.mu.Unlock()
+	}
+}
+
+func (q *queue) wait() {
+	q.wg.Wait()
+}